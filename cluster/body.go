@@ -0,0 +1,25 @@
+package cluster
+
+import (
+	"context"
+	"io"
+)
+
+// A lazyBody is a ranged blob body fetched by the menmos client one request per read.
+// Every read goes through the limits & retry policy of the client like any other request.
+type lazyBody struct {
+	io.ReadCloser
+
+	ctx    context.Context
+	client *Client
+}
+
+func (b *lazyBody) Read(p []byte) (int, error) {
+	var n int
+	// Failed reads don't advance the body, so they can be retried.
+	err := b.client.send(b.ctx, transferRequest, true, func() (err error) {
+		n, err = b.ReadCloser.Read(p)
+		return
+	})
+	return n, err
+}
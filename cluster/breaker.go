@@ -0,0 +1,99 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrClusterUnavailable is returned without contacting the cluster while the circuit breaker is open.
+var ErrClusterUnavailable = errors.New("cluster unavailable: too many consecutive failures")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// A breaker fails requests fast while the cluster is down.
+// After `threshold` consecutive transient failures it opens for `cooldown`, then lets a single trial request through:
+// the breaker closes if it succeeds, and opens again otherwise.
+type breaker struct {
+	mutex sync.Mutex
+
+	threshold int
+	cooldown  time.Duration
+
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow returns ErrClusterUnavailable if a request must not be sent.
+func (b *breaker) allow() error {
+	if b.threshold < 0 {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrClusterUnavailable
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		// A trial request is in flight.
+		return ErrClusterUnavailable
+	}
+	return nil
+}
+
+// record updates the breaker with the outcome of a request.
+func (b *breaker) record(err error) {
+	if b.threshold < 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// The request was given up before we learned anything about the cluster, let the next one try instead.
+		if b.state == breakerHalfOpen {
+			b.state = breakerOpen
+		}
+		return
+	}
+
+	if !isTransient(err) {
+		// Errors like "not found" still mean the cluster is up.
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// isOpen returns whether requests currently fail fast.
+func (b *breaker) isOpen() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.state != breakerClosed
+}
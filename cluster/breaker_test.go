@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterConsecutiveTransientFailures(t *testing.T) {
+	b := newBreaker(3, time.Hour)
+
+	b.record(io.ErrUnexpectedEOF)
+	b.record(io.ErrUnexpectedEOF)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected the breaker to stay closed below its threshold, got %v", err)
+	}
+
+	b.record(io.ErrUnexpectedEOF)
+	if err := b.allow(); err != ErrClusterUnavailable {
+		t.Errorf("expected the breaker to be open, got %v", err)
+	}
+	if !b.isOpen() {
+		t.Error("expected the breaker to report being open")
+	}
+}
+
+func TestBreakerResetsOnOtherOutcomes(t *testing.T) {
+	b := newBreaker(2, time.Hour)
+
+	b.record(io.ErrUnexpectedEOF)
+	b.record(errors.New("get meta: blob 'abc' not found"))
+	b.record(io.ErrUnexpectedEOF)
+	if err := b.allow(); err != nil {
+		t.Errorf("expected non-transient errors to reset the failure count, got %v", err)
+	}
+
+	b.record(io.ErrUnexpectedEOF)
+	b.record(context.Canceled)
+	if err := b.allow(); err != ErrClusterUnavailable {
+		t.Errorf("expected cancelled requests not to reset the failure count, got %v", err)
+	}
+}
+
+func TestBreakerLetsASingleTrialThrough(t *testing.T) {
+	b := newBreaker(1, time.Millisecond)
+
+	b.record(io.ErrUnexpectedEOF)
+	time.Sleep(2 * time.Millisecond)
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a trial request once the cooldown elapsed, got %v", err)
+	}
+	if err := b.allow(); err != ErrClusterUnavailable {
+		t.Errorf("expected a single trial request at a time, got %v", err)
+	}
+
+	// A failed trial opens the breaker again.
+	b.record(io.ErrUnexpectedEOF)
+	if err := b.allow(); err != ErrClusterUnavailable {
+		t.Errorf("expected the breaker to open again, got %v", err)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("expected a trial request once the cooldown elapsed, got %v", err)
+	}
+	b.record(nil)
+	if b.isOpen() {
+		t.Error("expected a successful trial to close the breaker")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := newBreaker(-1, time.Hour)
+
+	for i := 0; i < 10; i++ {
+		b.record(io.ErrUnexpectedEOF)
+	}
+	if err := b.allow(); err != nil {
+		t.Errorf("expected a disabled breaker to allow every request, got %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+// Package cluster wraps the menmos client with the policies applied to every request the mount sends to the cluster.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/menmos/menmos-go"
+	"github.com/menmos/menmos-go/payload"
+	"github.com/rclone/rclone/fs"
+)
+
+// Client sends requests to a menmos cluster, subject to the configured limits and retry policy.
+// All methods honor the cancellation of their context. Queries return as soon as their context is cancelled,
+// other requests wait for the cluster to respond so their outcome is known and their body can be closed.
+type Client struct {
+	client *menmos.Client
+
+	limiter *limiter
+	retry   RetryPolicy
+	breaker *breaker
+
+	offline int32 // Set atomically.
+}
+
+// NewClient wraps a menmos client.
+func NewClient(client *menmos.Client, limits Limits, retry RetryPolicy) *Client {
+	retry = retry.withDefaults()
+	return &Client{
+		client:  client,
+		limiter: newLimiter(limits),
+		retry:   retry,
+		breaker: newBreaker(retry.BreakerThreshold, time.Duration(retry.BreakerCooldown)),
+	}
+}
+
+// Online returns false once a request failed because the cluster is unreachable, until a request succeeds again.
+func (c *Client) Online() bool {
+	return atomic.LoadInt32(&c.offline) == 0 && !c.breaker.isOpen()
+}
+
+// IsUnavailable returns whether a request failed because the cluster couldn't be reached.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrClusterUnavailable) || isTransient(err)
+}
+
+// IsNotFound returns whether a request failed because the blob it targets doesn't exist.
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if match := statusPattern.FindStringSubmatch(err.Error()); match != nil {
+		return match[1] == "404"
+	}
+	return strings.HasSuffix(err.Error(), "not found")
+}
+
+// observe tracks whether the cluster is reachable from the outcome of a request.
+func (c *Client) observe(err error) {
+	if err == nil {
+		if atomic.CompareAndSwapInt32(&c.offline, 1, 0) {
+			fs.Logf(nil, "menmos cluster is reachable again")
+		}
+	} else if IsUnavailable(err) {
+		if atomic.CompareAndSwapInt32(&c.offline, 0, 1) {
+			fs.Logf(nil, "menmos cluster is unreachable, serving cached data: %v", err)
+		}
+	}
+}
+
+// IsHealthy returns whether the menmos cluster is healthy.
+// Health checks are neither rate limited nor retried, and are sent even while the circuit breaker is open:
+// a successful health check closes it.
+func (c *Client) IsHealthy(ctx context.Context) (bool, error) {
+	var healthy bool
+	err := c.abandonable(ctx, func() error {
+		release, err := c.limiter.acquire(ctx, noRequestClass)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		healthy, err = c.client.IsHealthy()
+		c.breaker.record(err)
+		c.observe(err)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return healthy, nil
+}
+
+// Query executes a query on the menmos cluster.
+func (c *Client) Query(ctx context.Context, query *payload.Query) (*payload.QueryResponse, error) {
+	var response *payload.QueryResponse
+	err := c.abandonable(ctx, func() error {
+		return c.send(ctx, queryRequest, true, func() (err error) {
+			response, err = c.client.Query(query)
+			return
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// GetMetadata returns the metadata of a blob.
+func (c *Client) GetMetadata(ctx context.Context, blobID string) (payload.BlobMeta, error) {
+	var meta payload.BlobMeta
+	err := c.abandonable(ctx, func() error {
+		return c.send(ctx, queryRequest, true, func() (err error) {
+			meta, err = c.client.GetMetadata(blobID)
+			return
+		})
+	})
+	if err != nil {
+		return payload.BlobMeta{}, err
+	}
+	return meta, nil
+}
+
+// GetBody returns the body of a blob, or the section of it within `readRange` if it is non-nil.
+func (c *Client) GetBody(ctx context.Context, blobID string, readRange *menmos.Range) (io.ReadCloser, error) {
+	if readRange != nil {
+		// Ranged bodies are fetched lazily: nothing is sent until the body is read, then each read is a request.
+		body, err := c.client.GetBody(blobID, readRange)
+		if err != nil {
+			return nil, err
+		}
+		return &lazyBody{ReadCloser: body, ctx: ctx, client: c}, nil
+	}
+
+	var body io.ReadCloser
+	err := c.send(ctx, transferRequest, true, func() (err error) {
+		body, err = c.client.GetBody(blobID, nil)
+		return
+	})
+	return body, err
+}
+
+// Delete deletes a blob from the cluster.
+// Deletions aren't retried, since a retry would fail if the first attempt went through.
+func (c *Client) Delete(ctx context.Context, blobID string) error {
+	return c.send(ctx, metadataRequest, false, func() error {
+		return c.client.Delete(blobID)
+	})
+}
+
+// CreateBlob creates a blob with the provided body and metadata, and returns its ID.
+// If the body is nil, the blob is created empty.
+// The menmos client returns an empty ID without an error when the cluster doesn't create the blob, which is reported
+// as an error here.
+func (c *Client) CreateBlob(ctx context.Context, body io.ReadCloser, meta payload.BlobMeta) (string, error) {
+	var blobID string
+	err := c.sendUpload(ctx, func() (err error) {
+		if blobID, err = c.client.CreateBlob(body, meta); err == nil && blobID == "" {
+			err = fmt.Errorf("failed to create blob '%s': no blob ID returned", meta.Name)
+		}
+		return
+	})
+	return blobID, err
+}
+
+// UpdateBlob replaces the body and metadata of a blob.
+// Uploads aren't retried since their body can only be read once.
+// Unlike CreateBlob, a blob the cluster failed to update can't be told apart from an updated one: the menmos client
+// drops the error of the upload itself.
+func (c *Client) UpdateBlob(ctx context.Context, blobID string, body io.ReadCloser, meta payload.BlobMeta) error {
+	return c.sendUpload(ctx, func() error {
+		return c.client.UpdateBlob(blobID, body, meta)
+	})
+}
+
+// UpdateMeta replaces the metadata of a blob.
+func (c *Client) UpdateMeta(ctx context.Context, blobID string, meta payload.BlobMeta) error {
+	return c.send(ctx, metadataRequest, true, func() error {
+		return c.client.UpdateMeta(blobID, meta)
+	})
+}
+
+// send runs a request through the circuit breaker and the limits.
+// Idempotent requests failing with a transient error are retried according to the retry policy.
+// The context is only honored while waiting, not once a request is sent.
+func (c *Client) send(ctx context.Context, class requestClass, idempotent bool, do func() error) error {
+	err := c.sendWithRetries(ctx, class, idempotent, do)
+	c.observe(err)
+	return err
+}
+
+func (c *Client) sendWithRetries(ctx context.Context, class requestClass, idempotent bool, do func() error) error {
+	maxAttempts := 1
+	if idempotent {
+		maxAttempts = c.retry.MaxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := c.breaker.allow(); err != nil {
+			return err
+		}
+
+		release, err := c.limiter.acquire(ctx, class)
+		if err != nil {
+			c.breaker.record(err)
+			return err
+		}
+
+		err = do()
+		release()
+		c.breaker.record(err)
+
+		if err == nil || !isTransient(err) || attempt >= maxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(c.retry.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// sendUpload runs an upload through the circuit breaker and the rate limit of transfers.
+// Uploads don't take a concurrency slot: their body is read while the request is in flight, and reading it might
+// need a slot of its own (e.g. a ranged read of another blob), which would deadlock once every slot is taken.
+func (c *Client) sendUpload(ctx context.Context, do func() error) error {
+	err := c.breaker.allow()
+	if err == nil {
+		err = c.limiter.wait(ctx, transferRequest)
+		if err == nil {
+			err = do()
+		}
+		c.breaker.record(err)
+	}
+
+	c.observe(err)
+	return err
+}
+
+// abandonable runs a request without side effects, returning early if the context is cancelled.
+// The menmos client doesn't support contexts: a cancelled request still completes in the background
+// (holding its concurrency slot until then), but its result is dropped.
+func (c *Client) abandonable(ctx context.Context, request func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- request()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,51 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/menmos/menmos-go"
+	"github.com/menmos/menmos-go/payload"
+)
+
+func TestIsNotFound(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil: false,
+		errors.New("get meta: blob 'abc' not found"):    true,
+		errors.New("unexpected status '404 Not Found'"): true,
+		errors.New("unexpected status '500 Internal'"):  false,
+		errors.New("connection refused"):                false,
+	} {
+		if actual := IsNotFound(err); actual != expected {
+			t.Errorf("expected IsNotFound(%v) to be %v", err, expected)
+		}
+	}
+}
+
+func TestCreateBlobWithoutID(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/auth/login":
+			json.NewEncoder(w).Encode(payload.LoginResponse{Token: "token"})
+		case r.URL.Query().Get("node") == "":
+			http.Redirect(w, r, r.URL.Path+"?node=1", http.StatusTemporaryRedirect)
+		default:
+			http.Error(w, "storage node failure", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	menmosClient, err := menmos.New(server.URL, "user", "password")
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	client := NewClient(menmosClient, Limits{}, RetryPolicy{})
+
+	if blobID, err := client.CreateBlob(context.Background(), nil, payload.NewBlobMeta("file", "File", 0)); err == nil {
+		t.Errorf("expected a blob created without an ID to fail, got '%s'", blobID)
+	}
+}
@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"context"
+	"math"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits bound the load the mount puts on the cluster. Zero values mean no limit.
+type Limits struct {
+	// MaxConcurrentRequests is the maximum number of requests waiting for a response at any time.
+	// Uploads aren't counted: they are in flight while their body is read, which might itself need a request.
+	MaxConcurrentRequests int `json:"max_concurrent_requests,omitempty"`
+
+	// QueriesPerSecond limits the rate of queries and metadata reads.
+	QueriesPerSecond float64 `json:"queries_per_second,omitempty"`
+	// MetadataUpdatesPerSecond limits the rate of metadata updates and deletions.
+	MetadataUpdatesPerSecond float64 `json:"metadata_updates_per_second,omitempty"`
+	// TransfersPerSecond limits the rate of body uploads and downloads.
+	TransfersPerSecond float64 `json:"transfers_per_second,omitempty"`
+}
+
+type requestClass int
+
+const (
+	noRequestClass requestClass = iota
+	queryRequest
+	metadataRequest
+	transferRequest
+)
+
+// A limiter enforces Limits: each request class has its own token bucket, and all classes share the concurrency limit.
+type limiter struct {
+	slots chan struct{} // nil if concurrency is unlimited.
+	rates map[requestClass]*rate.Limiter
+}
+
+func newLimiter(limits Limits) *limiter {
+	l := &limiter{rates: make(map[requestClass]*rate.Limiter)}
+
+	if limits.MaxConcurrentRequests > 0 {
+		l.slots = make(chan struct{}, limits.MaxConcurrentRequests)
+	}
+
+	for class, perSecond := range map[requestClass]float64{
+		queryRequest:    limits.QueriesPerSecond,
+		metadataRequest: limits.MetadataUpdatesPerSecond,
+		transferRequest: limits.TransfersPerSecond,
+	} {
+		if perSecond > 0 {
+			// Allow bursts of up to one second worth of requests.
+			l.rates[class] = rate.NewLimiter(rate.Limit(perSecond), int(math.Ceil(perSecond)))
+		}
+	}
+
+	return l
+}
+
+// acquire waits until a request of the given class can be sent.
+// The returned function must be called once the request completed.
+func (l *limiter) acquire(ctx context.Context, class requestClass) (func(), error) {
+	if err := l.wait(ctx, class); err != nil {
+		return nil, err
+	}
+
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// wait waits until the rate limit of a request class allows another request, without taking a concurrency slot.
+func (l *limiter) wait(ctx context.Context, class requestClass) error {
+	if bucket, ok := l.rates[class]; ok {
+		return bucket.Wait(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterBoundsConcurrentRequests(t *testing.T) {
+	l := newLimiter(Limits{MaxConcurrentRequests: 2})
+
+	first, err := l.acquire(context.Background(), queryRequest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := l.acquire(context.Background(), transferRequest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, metadataRequest); err != context.DeadlineExceeded {
+		t.Errorf("expected every slot to be taken, got %v", err)
+	}
+
+	first()
+	if _, err := l.acquire(context.Background(), metadataRequest); err != nil {
+		t.Errorf("expected a released slot to be available, got %v", err)
+	}
+}
+
+func TestLimiterWaitDoesNotTakeSlots(t *testing.T) {
+	l := newLimiter(Limits{MaxConcurrentRequests: 1})
+
+	if _, err := l.acquire(context.Background(), queryRequest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, transferRequest); err != nil {
+		t.Errorf("expected uploads not to wait for a slot, got %v", err)
+	}
+}
+
+func TestLimiterRatesAreIndependent(t *testing.T) {
+	l := newLimiter(Limits{QueriesPerSecond: 1})
+
+	// The first request uses the burst, the second one has to wait a full second.
+	if err := l.wait(context.Background(), queryRequest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx, queryRequest); err == nil {
+		t.Error("expected the query rate to be limited")
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := l.wait(ctx, transferRequest); err != nil {
+			t.Errorf("expected transfers not to be limited, got %v", err)
+		}
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := newLimiter(Limits{})
+
+	for i := 0; i < 100; i++ {
+		if _, err := l.acquire(context.Background(), queryRequest); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+// Default retry policy.
+const (
+	DefaultMaxAttempts      = 3
+	DefaultInitialBackoff   = 100 * time.Millisecond
+	DefaultMaxBackoff       = 5 * time.Second
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+)
+
+// A RetryPolicy describes how requests failing because of transient cluster errors are retried.
+// Only idempotent requests are retried. Zero values use the defaults.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a request is sent. 1 disables retries.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// InitialBackoff is the upper bound of the delay before the first retry, it doubles with each attempt.
+	InitialBackoff fs.Duration `json:"initial_backoff,omitempty"`
+	// MaxBackoff caps the delay between two attempts.
+	MaxBackoff fs.Duration `json:"max_backoff,omitempty"`
+
+	// BreakerThreshold is the number of consecutive transient failures after which requests fail fast.
+	// A negative value disables the circuit breaker.
+	BreakerThreshold int `json:"breaker_threshold,omitempty"`
+	// BreakerCooldown is how long requests fail fast before a request is allowed through again.
+	BreakerCooldown fs.Duration `json:"breaker_cooldown,omitempty"`
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = fs.Duration(DefaultInitialBackoff)
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = fs.Duration(DefaultMaxBackoff)
+	}
+	if p.BreakerThreshold == 0 {
+		p.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if p.BreakerCooldown <= 0 {
+		p.BreakerCooldown = fs.Duration(DefaultBreakerCooldown)
+	}
+	return p
+}
+
+// backoff returns the delay before retrying a request that failed `attempt` times.
+// The delay is drawn uniformly up to an exponentially growing bound, so clients retrying together spread out.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	bound := time.Duration(p.MaxBackoff)
+	if attempt < 32 {
+		if exponential := time.Duration(p.InitialBackoff) << (attempt - 1); exponential > 0 && exponential < bound {
+			bound = exponential
+		}
+	}
+	return time.Duration(rand.Int63n(int64(bound) + 1))
+}
+
+// The menmos client only reports unexpected statuses in its error messages.
+var statusPattern = regexp.MustCompile(`unexpected status '(\d{3})`)
+
+// isTransient returns whether an error is likely to go away if the request is sent again.
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	if match := statusPattern.FindStringSubmatch(err.Error()); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		return status == 429 || status >= 500
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rclone/rclone/fs"
+)
+
+func TestBackoffStaysWithinBounds(t *testing.T) {
+	policy := RetryPolicy{
+		InitialBackoff: fs.Duration(100 * time.Millisecond),
+		MaxBackoff:     fs.Duration(time.Second),
+	}.withDefaults()
+
+	for attempt, bound := range map[int]time.Duration{
+		1:  100 * time.Millisecond,
+		2:  200 * time.Millisecond,
+		4:  800 * time.Millisecond,
+		5:  time.Second,
+		40: time.Second,
+	} {
+		for i := 0; i < 100; i++ {
+			if backoff := policy.backoff(attempt); backoff < 0 || backoff > bound {
+				t.Fatalf("expected the backoff of attempt %d to be within [0, %v], got %v", attempt, bound, backoff)
+			}
+		}
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	policy := RetryPolicy{BreakerThreshold: -1}.withDefaults()
+
+	if policy.MaxAttempts != DefaultMaxAttempts {
+		t.Errorf("expected %d attempts, got %d", DefaultMaxAttempts, policy.MaxAttempts)
+	}
+	if time.Duration(policy.InitialBackoff) != DefaultInitialBackoff || time.Duration(policy.MaxBackoff) != DefaultMaxBackoff {
+		t.Errorf("unexpected backoff bounds: %v, %v", policy.InitialBackoff, policy.MaxBackoff)
+	}
+	if policy.BreakerThreshold != -1 {
+		t.Errorf("expected the circuit breaker to stay disabled, got a threshold of %d", policy.BreakerThreshold)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	var _ net.Error = timeoutError{}
+
+	for err, expected := range map[error]bool{
+		nil:            false,
+		timeoutError{}: true,
+		fmt.Errorf("wrapped: %w", timeoutError{}):                 true,
+		io.ErrUnexpectedEOF:                                       true,
+		errors.New("unexpected status '503 Service Unavailable'"): true,
+		errors.New("unexpected status '429 Too Many Requests'"):   true,
+		errors.New("unexpected status '404 Not Found'"):           false,
+		errors.New("get meta: blob 'abc' not found"):              false,
+	} {
+		if actual := isTransient(err); actual != expected {
+			t.Errorf("expected isTransient(%v) to be %v", err, expected)
+		}
+	}
+}
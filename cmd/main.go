@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
 
@@ -31,7 +33,40 @@ func initMount(c *cli.Context) error {
 		return err
 	}
 
-	return mount.Wait()
+	err = mount.Wait()
+
+	// Give the filesystem a chance to flush its state once unmounted.
+	if shutdown := mount.Fs.Features().Shutdown; shutdown != nil {
+		if shutdownErr := shutdown(context.Background()); err == nil {
+			err = shutdownErr
+		}
+	}
+
+	return err
+}
+
+func linkFile(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("expected a source and a destination path")
+	}
+
+	cfg, err := getMountConfig(c)
+	if err != nil {
+		return err
+	}
+
+	// The link is made right away: the journal and the persistent cache belong to the mount, which might be running.
+	cfg.Journal = false
+	cfg.PersistentCache = false
+
+	ctx := context.Background()
+	f, err := filesystem.NewFs(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer f.Features().Shutdown(ctx)
+
+	return f.(*filesystem.Filesystem).Link(ctx, c.Args().Get(0), c.Args().Get(1))
 }
 
 func main() {
@@ -53,6 +88,14 @@ func main() {
 	}
 
 	app.Action = initMount
+	app.Commands = []*cli.Command{
+		{
+			Name:      "link",
+			Usage:     "make a file also appear in another directory of the mount, without copying its data",
+			ArgsUsage: "<source> <destination>",
+			Action:    linkFile,
+		},
+	}
 
 	if err := app.Run(os.Args); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,16 @@
+// Command rclone is a build of rclone including the menmos backend, so regular rclone commands can target a menmos
+// cluster, e.g. `rclone copy ./data menmos:photos`.
+package main
+
+import (
+	"github.com/rclone/rclone/cmd"
+
+	// The local backend is required for transfers between the local disk and a menmos remote.
+	_ "github.com/menmos/menmos-mount/filesystem"
+	_ "github.com/rclone/rclone/backend/local"
+	_ "github.com/rclone/rclone/cmd/all"
+)
+
+func main() {
+	cmd.Main()
+}
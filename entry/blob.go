@@ -2,10 +2,12 @@ package entry
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"time"
 
-	"github.com/menmos/menmos-go"
 	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
 	"github.com/rclone/rclone/fs"
 )
 
@@ -13,8 +15,11 @@ type BlobEntry struct {
 	BlobID string
 	Meta   payload.BlobMeta
 
+	// ParentID is the blob ID of the directory this entry was listed from, if any.
+	ParentID string
+
 	path   string
-	client *menmos.Client
+	client *cluster.Client
 	fs     fs.Info
 }
 
@@ -26,10 +31,159 @@ func (e *BlobEntry) Remote() string {
 	return e.path
 }
 
+// ModTime returns the modification time of the blob, or the Unix epoch if it was never set.
 func (e *BlobEntry) ModTime(context.Context) time.Time {
-	return time.Unix(0, 0)
+	return getMetaTime(e.Meta, ModTimeMetaKey)
+}
+
+// ChangeTime returns the time of the last change to the blob contents or metadata, or the Unix epoch if it was never set.
+func (e *BlobEntry) ChangeTime() time.Time {
+	return getMetaTime(e.Meta, ChangeTimeMetaKey)
 }
 
 func (e *BlobEntry) Size() int64 {
 	return int64(e.Meta.Size) // Technically not super safe to cast u64 to i64, but this will only fail on files above 9200 Pb... We should be OK.
 }
+
+// unlink removes the entry from its parent directory, through the journal of the filesystem if it has one.
+func (e *BlobEntry) unlink(ctx context.Context) error {
+	if journal, ok := e.fs.(Journal); ok {
+		return journal.JournalUnlink(ctx, e)
+	}
+	return e.Unlink(ctx)
+}
+
+// Unlink removes the entry from its parent directory right away, bypassing the journal of the filesystem.
+// Blobs with other parents are kept around, the blob is only deleted once its last parent is removed.
+// Parents are read from the cluster since listed metadata might be stale. Entries listed from a query have no parent
+// directory, so they can only be removed if their blob isn't in any directory.
+func (e *BlobEntry) Unlink(ctx context.Context) error {
+	meta, err := e.client.GetMetadata(ctx, e.BlobID)
+	if err != nil {
+		return err
+	}
+
+	if e.ParentID == "" && len(meta.Parents) > 0 {
+		return fmt.Errorf("'%s' is still in %d directories: %w", e.path, len(meta.Parents), fs.ErrorPermissionDenied)
+	}
+
+	remainingParents := make([]string, 0, len(meta.Parents))
+	for _, parentID := range meta.Parents {
+		if parentID != e.ParentID {
+			remainingParents = append(remainingParents, parentID)
+		}
+	}
+
+	if e.ParentID != "" && len(remainingParents) == len(meta.Parents) {
+		// The blob was already removed from this directory, e.g. by another client.
+		e.Meta = meta
+		e.changed()
+		return nil
+	}
+
+	if len(remainingParents) == 0 {
+		if err := e.client.Delete(ctx, e.BlobID); err != nil {
+			return err
+		}
+		e.changed()
+		return nil
+	}
+
+	meta.Parents = remainingParents
+	if err := e.client.UpdateMeta(ctx, e.BlobID, meta); err != nil {
+		return err
+	}
+
+	e.Meta = meta
+	e.changed()
+	return nil
+}
+
+// A ListingInvalidator drops cached listings when the children of a directory blob change.
+// Entries notify their filesystem through this interface if it implements it.
+type ListingInvalidator interface {
+	// InvalidateListing drops the cached listing of a directory blob, or every cached listing if parentID is empty.
+	InvalidateListing(parentID string)
+}
+
+// A Journal records the changes made to entries on disk before sending them to the cluster, so changes which can't
+// be sent right away are replayed later instead of being lost.
+// Entries send their changes through the journal of their filesystem if it implements this interface.
+type Journal interface {
+	// JournalUpload sends the body of a file with FileBlobEntry.Upload once it is recorded.
+	// It returns true if the upload was recorded but will only be sent later.
+	JournalUpload(ctx context.Context, file *FileBlobEntry, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption) (bool, error)
+	// JournalUpdateMeta replaces the metadata of the blob of an entry.
+	JournalUpdateMeta(ctx context.Context, entry *BlobEntry, meta payload.BlobMeta) error
+	// JournalUnlink removes an entry from its parent directory with BlobEntry.Unlink once it is recorded.
+	JournalUnlink(ctx context.Context, entry *BlobEntry) error
+}
+
+// updateMeta replaces the metadata of the blob, through the journal of the filesystem if it has one.
+func (e *BlobEntry) updateMeta(ctx context.Context, meta payload.BlobMeta) error {
+	if journal, ok := e.fs.(Journal); ok {
+		return journal.JournalUpdateMeta(ctx, e, meta)
+	}
+	return e.client.UpdateMeta(ctx, e.BlobID, meta)
+}
+
+// A BodyCacheProvider gives entries access to the body cache of their filesystem.
+// Entries only cache bodies if their filesystem implements this interface.
+type BodyCacheProvider interface {
+	BodyCache() *BodyCache
+}
+
+// A ReadCacheProvider gives entries access to the read cache of their filesystem.
+// Entries read bodies by chunks if their filesystem implements this interface, and by ranged requests otherwise.
+type ReadCacheProvider interface {
+	ReadCache() *ReadCache
+}
+
+// A KnownVersionsProvider gives entries access to the versions of the blobs read or written through their filesystem.
+// Uploads are based on the version their entry was listed with if the filesystem doesn't implement this interface.
+type KnownVersionsProvider interface {
+	KnownVersions() *KnownVersions
+}
+
+// knownVersions returns the known versions of the filesystem of the entry, if any.
+func (e *BlobEntry) knownVersions() *KnownVersions {
+	if provider, ok := e.fs.(KnownVersionsProvider); ok {
+		return provider.KnownVersions()
+	}
+	return nil
+}
+
+// readCache returns the read cache of the filesystem of the entry, if any.
+func (e *BlobEntry) readCache() *ReadCache {
+	if provider, ok := e.fs.(ReadCacheProvider); ok {
+		return provider.ReadCache()
+	}
+	return nil
+}
+
+// bodyCache returns the body cache of the filesystem of the entry, if any.
+func (e *BlobEntry) bodyCache() *BodyCache {
+	if provider, ok := e.fs.(BodyCacheProvider); ok {
+		return provider.BodyCache()
+	}
+	return nil
+}
+
+// changed notifies the filesystem that the entry was modified, so the listings of its parents are refreshed.
+func (e *BlobEntry) changed() {
+	invalidator, ok := e.fs.(ListingInvalidator)
+	if !ok {
+		return
+	}
+
+	// Entries listed from a query rather than a directory can appear in any listing.
+	if e.ParentID == "" {
+		invalidator.InvalidateListing("")
+		return
+	}
+
+	invalidator.InvalidateListing(e.ParentID)
+	for _, parentID := range e.Meta.Parents {
+		invalidator.InvalidateListing(parentID)
+	}
+}
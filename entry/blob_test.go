@@ -0,0 +1,149 @@
+package entry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/menmos/menmos-go"
+	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
+	"github.com/rclone/rclone/fs"
+)
+
+// A fakeCluster serves the metadata of a single blob, and records the changes sent to it.
+type fakeCluster struct {
+	mutex   sync.Mutex
+	meta    payload.BlobMeta
+	deleted bool
+	updated bool
+	created []string
+}
+
+func (c *fakeCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Changes are redirected to a storage node first.
+	if r.Method != http.MethodGet && r.URL.Path != "/auth/login" && r.URL.Query().Get("node") == "" {
+		http.Redirect(w, r, r.URL.Path+"?node=1", http.StatusTemporaryRedirect)
+		return
+	}
+
+	var response interface{} = payload.MessageResponse{Message: "ok"}
+	switch {
+	case r.URL.Path == "/auth/login":
+		response = payload.LoginResponse{Token: "token"}
+	case r.Method == http.MethodGet:
+		response = payload.GetMetadataResponse{Metadata: &c.meta}
+	case r.Method == http.MethodDelete:
+		c.deleted = true
+	case r.Method == http.MethodPost:
+		var meta payload.BlobMeta
+		if encoded, err := base64.StdEncoding.DecodeString(r.Header.Get("X-Blob-Meta")); err == nil {
+			json.Unmarshal(encoded, &meta)
+		}
+		c.created = append(c.created, meta.Name)
+		response = payload.PushResponse{ID: "copy"}
+	case r.Method == http.MethodPut:
+		c.updated = true
+		json.NewDecoder(r.Body).Decode(&c.meta)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func unlinkFrom(t *testing.T, parents []string, parentID string) (*fakeCluster, error) {
+	t.Helper()
+
+	fake := &fakeCluster{meta: payload.BlobMeta{Name: "file", Parents: parents}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := menmos.New(server.URL, "user", "password")
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	// The listed metadata is stale: the parents of the blob are read from the cluster.
+	file := NewFile("blob", payload.BlobMeta{Name: "file"}, "file", cluster.NewClient(client, cluster.Limits{}, cluster.RetryPolicy{}), nil)
+	file.ParentID = parentID
+	return fake, file.Unlink(context.Background())
+}
+
+func TestUnlinkKeepsBlobsWithOtherParents(t *testing.T) {
+	fake, err := unlinkFrom(t, []string{"a", "b"}, "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fake.deleted || len(fake.meta.Parents) != 1 || fake.meta.Parents[0] != "b" {
+		t.Errorf("expected the blob to be kept in 'b', got %v (deleted: %v)", fake.meta.Parents, fake.deleted)
+	}
+}
+
+func TestUnlinkDeletesBlobsWithoutOtherParents(t *testing.T) {
+	fake, err := unlinkFrom(t, []string{"a"}, "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !fake.deleted {
+		t.Error("expected the blob to be deleted")
+	}
+}
+
+func TestUnlinkFromAnotherDirectory(t *testing.T) {
+	fake, err := unlinkFrom(t, []string{"b"}, "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fake.deleted || fake.updated {
+		t.Error("expected a blob already removed from the directory to be left untouched")
+	}
+}
+
+func TestUnlinkWithoutDirectory(t *testing.T) {
+	fake, err := unlinkFrom(t, []string{"a"}, "")
+	if !errors.Is(err, fs.ErrorPermissionDenied) {
+		t.Errorf("expected a blob still in a directory not to be removed from a query, got %v", err)
+	}
+	if fake.deleted || fake.updated {
+		t.Error("expected the blob to be left untouched")
+	}
+
+	if fake, err := unlinkFrom(t, nil, ""); err != nil || !fake.deleted {
+		t.Errorf("expected a blob in no directory to be deleted, got %v", err)
+	}
+}
+
+func TestSetModTimeKeepsRemoteMetadata(t *testing.T) {
+	fake := &fakeCluster{meta: payload.BlobMeta{Name: "file", Tags: []string{"remote"}, Metadata: map[string]string{"color": "blue"}}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := menmos.New(server.URL, "user", "password")
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	// The listed metadata is stale: the tags & metadata set by other clients since are kept.
+	file := NewFile("blob", payload.BlobMeta{Name: "file"}, "file", cluster.NewClient(client, cluster.Limits{}, cluster.RetryPolicy{}), nil)
+	modTime := time.Unix(42, 0)
+	if err := file.SetModTime(context.Background(), modTime); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(fake.meta.Tags) != 1 || fake.meta.Metadata["color"] != "blue" {
+		t.Errorf("expected the remote metadata to be kept, got %+v", fake.meta)
+	}
+	if fake.meta.Metadata[ModTimeMetaKey] != formatTime(modTime) {
+		t.Errorf("expected the modification time to be saved, got %+v", fake.meta.Metadata)
+	}
+	if file.Meta.Metadata[ModTimeMetaKey] != formatTime(modTime) {
+		t.Errorf("expected the entry to have the new modification time, got %+v", file.Meta.Metadata)
+	}
+}
@@ -0,0 +1,257 @@
+package entry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+// A BodyCache keeps the bodies of recently read blobs on disk, so they can still be read while the cluster is
+// unreachable. Bodies are stored by SHA-256, or by version for blobs without one (see bodyCacheKey).
+// Keys come from listed metadata, which another writer might have left stale, so cached bodies are only served while
+// the cluster is unreachable.
+// A nil cache caches nothing.
+type BodyCache struct {
+	mutex sync.Mutex
+
+	directory string
+	maxSize   int64
+}
+
+// NewBodyCache returns a cache keeping at most `maxSize` bytes of bodies in `directory`.
+// It returns nil (no caching) if maxSize isn't positive.
+func NewBodyCache(directory string, maxSize int64) (*BodyCache, error) {
+	if maxSize <= 0 {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create body cache directory")
+	}
+
+	return &BodyCache{directory: directory, maxSize: maxSize}, nil
+}
+
+// bodyCacheKey returns the key the body of a blob is cached under, along with the SHA-256 the body must match if it
+// is known. Bodies with a hash are keyed by it, so identical bodies share an entry. Other bodies are keyed by the
+// version of their blob: its ID, size and modification & change times, which every write through a mount changes.
+// Blobs with neither are not cached.
+func bodyCacheKey(blobID string, meta payload.BlobMeta) (string, string) {
+	if sum := meta.Metadata[SHA256MetaKey]; sum != "" {
+		return sum, sum
+	}
+
+	modTime, changeTime := meta.Metadata[ModTimeMetaKey], meta.Metadata[ChangeTimeMetaKey]
+	if modTime == "" && changeTime == "" {
+		return "", ""
+	}
+
+	version := sha256.Sum256([]byte(fmt.Sprintf("%s/%d/%s/%s", blobID, meta.Size, modTime, changeTime)))
+	return "version-" + hex.EncodeToString(version[:]), ""
+}
+
+func (c *BodyCache) path(key string) string {
+	return filepath.Join(c.directory, key)
+}
+
+// open returns the section of a cached body between `start` and `end` (inclusive), if the body is cached.
+func (c *BodyCache) open(key string, start int64, end int64) (io.ReadCloser, bool) {
+	if c == nil || key == "" {
+		return nil, false
+	}
+
+	file, err := os.Open(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	// The modification time of cached bodies tracks their last use, for eviction.
+	now := time.Now()
+	_ = os.Chtimes(file.Name(), now, now)
+
+	return &cachedBody{Reader: io.NewSectionReader(file, start, end-start+1), file: file}, true
+}
+
+// store returns a reader over `body` which saves the body to the cache under `key` once it was read entirely.
+// The body is only cached if its size matches the expected one, and so does its hash if `sum` isn't empty.
+func (c *BodyCache) store(key string, sum string, size int64, body io.ReadCloser) io.ReadCloser {
+	if c == nil || key == "" || size <= 0 || size > c.maxSize {
+		return body
+	}
+
+	tmpFile, err := os.CreateTemp(c.directory, key+".*")
+	if err != nil {
+		fs.Errorf(nil, "failed to cache blob body: %v", err)
+		return body
+	}
+
+	return &cachingBody{ReadCloser: body, cache: c, key: key, sum: sum, size: size, tmpFile: tmpFile, hasher: sha256.New()}
+}
+
+// commit moves a fully read body into the cache, then evicts the least recently used bodies above the size limit.
+func (c *BodyCache) commit(tmpPath string, key string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := os.Rename(tmpPath, c.path(key)); err != nil {
+		return err
+	}
+
+	dirEntries, err := os.ReadDir(c.directory)
+	if err != nil {
+		return err
+	}
+
+	var cached []os.FileInfo
+	var totalSize int64
+	for _, dirEntry := range dirEntries {
+		if strings.Contains(dirEntry.Name(), ".") {
+			// Bodies being downloaded.
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		cached = append(cached, info)
+		totalSize += info.Size()
+	}
+
+	sort.Slice(cached, func(i, j int) bool { return cached[i].ModTime().Before(cached[j].ModTime()) })
+	for _, info := range cached {
+		if totalSize <= c.maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.directory, info.Name())); err == nil {
+			totalSize -= info.Size()
+		}
+	}
+
+	return nil
+}
+
+// withFallback returns a reader over a body read from the cluster, which switches to the cached body under `key` if
+// the cluster turns out to be unreachable before anything was read. Bodies read from the cluster are fetched lazily,
+// so this is only known once they are read.
+func (c *BodyCache) withFallback(key string, start int64, end int64, body io.ReadCloser) io.ReadCloser {
+	if c == nil || key == "" {
+		return body
+	}
+
+	return &fallbackBody{ReadCloser: body, fallback: func() (io.ReadCloser, bool) { return c.open(key, start, end) }}
+}
+
+// A fallbackBody reads a body from the cluster, or from the body cache if the cluster is unreachable.
+type fallbackBody struct {
+	io.ReadCloser
+
+	fallback func() (io.ReadCloser, bool)
+	started  bool
+}
+
+func (b *fallbackBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.started && n == 0 && cluster.IsUnavailable(err) {
+		if cached, ok := b.fallback(); ok {
+			fs.Infof(nil, "cluster unreachable, reading cached body: %v", err)
+			b.ReadCloser.Close()
+			b.ReadCloser = cached
+			n, err = cached.Read(p)
+		}
+	}
+
+	b.started = true
+	return n, err
+}
+
+type cachedBody struct {
+	io.Reader
+
+	file *os.File
+}
+
+func (b *cachedBody) Close() error {
+	return b.file.Close()
+}
+
+// A cachingBody copies a body to a temporary file while it is read.
+type cachingBody struct {
+	io.ReadCloser
+
+	cache *BodyCache
+	key   string
+	sum   string // Empty if the hash of the body isn't known.
+	size  int64
+
+	tmpFile *os.File
+	hasher  hash.Hash
+	written int64
+	failed  bool
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	if n > 0 && b.tmpFile != nil && !b.failed {
+		if _, writeErr := b.tmpFile.Write(p[:n]); writeErr != nil {
+			b.failed = true
+		}
+		b.hasher.Write(p[:n])
+		b.written += int64(n)
+	}
+
+	if err == io.EOF {
+		b.finish()
+	}
+
+	return n, err
+}
+
+// finish caches the body if it was read entirely and matches its expected hash, if any.
+func (b *cachingBody) finish() {
+	if b.tmpFile == nil {
+		return
+	}
+
+	tmpPath := b.tmpFile.Name()
+	closeErr := b.tmpFile.Close()
+	b.tmpFile = nil
+
+	if b.failed || closeErr != nil || b.written != b.size || (b.sum != "" && hex.EncodeToString(b.hasher.Sum(nil)) != b.sum) {
+		os.Remove(tmpPath)
+		return
+	}
+
+	if err := b.cache.commit(tmpPath, b.key); err != nil {
+		fs.Errorf(nil, "failed to cache blob body: %v", err)
+		os.Remove(tmpPath)
+	}
+}
+
+func (b *cachingBody) Close() error {
+	// Bodies closed before their end are not cached.
+	if b.tmpFile != nil {
+		if b.written == b.size {
+			b.finish()
+		} else {
+			b.tmpFile.Close()
+			os.Remove(b.tmpFile.Name())
+			b.tmpFile = nil
+		}
+	}
+	return b.ReadCloser.Close()
+}
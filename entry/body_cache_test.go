@@ -0,0 +1,154 @@
+package entry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/menmos/menmos-go/payload"
+)
+
+func TestBodyCacheKey(t *testing.T) {
+	withHash := payload.BlobMeta{Size: 3, Metadata: map[string]string{SHA256MetaKey: "abc", ModTimeMetaKey: "1"}}
+	if key, sum := bodyCacheKey("blob", withHash); key != "abc" || sum != "abc" {
+		t.Errorf("expected bodies with a hash to be keyed by it, got '%s' and '%s'", key, sum)
+	}
+
+	withoutHash := payload.BlobMeta{Size: 3, Metadata: map[string]string{ModTimeMetaKey: "1"}}
+	key, sum := bodyCacheKey("blob", withoutHash)
+	if key == "" || sum != "" {
+		t.Errorf("expected bodies without a hash to be keyed by version, got '%s' and '%s'", key, sum)
+	}
+
+	for _, other := range []payload.BlobMeta{
+		{Size: 4, Metadata: map[string]string{ModTimeMetaKey: "1"}},
+		{Size: 3, Metadata: map[string]string{ModTimeMetaKey: "2"}},
+		{Size: 3, Metadata: map[string]string{ModTimeMetaKey: "1", ChangeTimeMetaKey: "2"}},
+	} {
+		if otherKey, _ := bodyCacheKey("blob", other); otherKey == key {
+			t.Errorf("expected %v to have another key than %v", other.Metadata, withoutHash.Metadata)
+		}
+	}
+	if otherKey, _ := bodyCacheKey("other", withoutHash); otherKey == key {
+		t.Error("expected bodies of other blobs to have another key")
+	}
+
+	if key, _ := bodyCacheKey("blob", payload.BlobMeta{Size: 3, Metadata: map[string]string{}}); key != "" {
+		t.Errorf("expected bodies without hash nor times not to be cached, got '%s'", key)
+	}
+}
+
+func readCached(t *testing.T, cache *BodyCache, key string, start int64, end int64) (string, bool) {
+	t.Helper()
+
+	body, ok := cache.open(key, start, end)
+	if !ok {
+		return "", false
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatalf("failed to read cached body: %v", err)
+	}
+	return string(data), true
+}
+
+func TestBodyCacheStoresFullyReadBodies(t *testing.T) {
+	cache, err := NewBodyCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte("hello"))
+	hashed := hex.EncodeToString(sum[:])
+
+	for key, expectedSum := range map[string]string{hashed: hashed, "version-1": ""} {
+		body := cache.store(key, expectedSum, 5, ioutil.NopCloser(strings.NewReader("hello")))
+		if _, err := io.Copy(ioutil.Discard, body); err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		body.Close()
+
+		if data, ok := readCached(t, cache, key, 1, 3); !ok || data != "ell" {
+			t.Errorf("expected '%s' to be cached, got '%s' (%v)", key, data, ok)
+		}
+	}
+}
+
+func TestBodyCacheRejectsMismatchingBodies(t *testing.T) {
+	cache, err := NewBodyCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	body := cache.store("abc", "abc", 5, ioutil.NopCloser(strings.NewReader("hello")))
+	io.Copy(ioutil.Discard, body)
+	body.Close()
+	if _, ok := cache.open("abc", 0, 4); ok {
+		t.Error("expected a body with another hash not to be cached")
+	}
+
+	body = cache.store("version-1", "", 6, ioutil.NopCloser(strings.NewReader("hello")))
+	io.Copy(ioutil.Discard, body)
+	body.Close()
+	if _, ok := cache.open("version-1", 0, 4); ok {
+		t.Error("expected a body with another size not to be cached")
+	}
+
+	body = cache.store("version-2", "", 5, ioutil.NopCloser(strings.NewReader("hello")))
+	body.Read(make([]byte, 2))
+	body.Close()
+	if _, ok := cache.open("version-2", 0, 4); ok {
+		t.Error("expected a body closed before its end not to be cached")
+	}
+}
+
+// failingBody fails with err once its data was read.
+type failingBody struct {
+	io.Reader
+	err error
+}
+
+func (b *failingBody) Read(p []byte) (int, error) {
+	if n, _ := b.Reader.Read(p); n > 0 {
+		return n, nil
+	}
+	return 0, b.err
+}
+
+func (b *failingBody) Close() error {
+	return nil
+}
+
+func TestBodyCacheFallsBackWhileTheClusterIsUnreachable(t *testing.T) {
+	cache, err := NewBodyCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	body := cache.store("version-1", "", 5, ioutil.NopCloser(strings.NewReader("hello")))
+	io.Copy(ioutil.Discard, body)
+	body.Close()
+
+	// The connection is refused: the cached body is read instead.
+	unreachable := cache.withFallback("version-1", 1, 3, &failingBody{Reader: strings.NewReader(""), err: io.ErrUnexpectedEOF})
+	if data, err := ioutil.ReadAll(unreachable); err != nil || string(data) != "ell" {
+		t.Errorf("expected the cached body to be read, got '%s' (%v)", data, err)
+	}
+
+	refused := errors.New("unexpected status '404 Not Found'")
+	if _, err := ioutil.ReadAll(cache.withFallback("version-1", 1, 3, &failingBody{Reader: strings.NewReader(""), err: refused})); err != refused {
+		t.Errorf("expected errors of a reachable cluster to be returned, got %v", err)
+	}
+
+	// Once part of the body was read from the cluster, the rest can't come from the cache.
+	interrupted := cache.withFallback("version-1", 1, 3, &failingBody{Reader: strings.NewReader("e"), err: io.ErrUnexpectedEOF})
+	if _, err := ioutil.ReadAll(interrupted); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected the read to fail, got %v", err)
+	}
+}
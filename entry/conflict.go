@@ -0,0 +1,92 @@
+package entry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/menmos/menmos-go/payload"
+	"github.com/rclone/rclone/fs"
+)
+
+// A Fingerprint identifies a version of the body of a blob.
+type Fingerprint struct {
+	Size    uint64 `json:"size"`
+	ModTime string `json:"mod_time,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+}
+
+// FingerprintOf returns the fingerprint of the body described by the metadata of a blob.
+func FingerprintOf(meta payload.BlobMeta) Fingerprint {
+	return Fingerprint{
+		Size:    meta.Size,
+		ModTime: meta.Metadata[ModTimeMetaKey],
+		SHA256:  meta.Metadata[SHA256MetaKey],
+	}
+}
+
+// Matches returns whether two fingerprints identify the same body.
+// Bodies are compared by hash when both sides know it, since blobs written by other clients might not have one.
+func (f Fingerprint) Matches(other Fingerprint) bool {
+	if f.Size != other.Size {
+		return false
+	}
+	if f.SHA256 != "" && other.SHA256 != "" {
+		return f.SHA256 == other.SHA256
+	}
+	return f.ModTime == other.ModTime
+}
+
+// BaseVersion returns the version of the body the next upload replaces: the Base of the entry if set, otherwise
+// the version last read or written through the filesystem (see KnownVersions), otherwise the version the entry was
+// listed with. Entries are often resolved again right before uploading, so their own metadata is only a last resort.
+func (b *FileBlobEntry) BaseVersion() Fingerprint {
+	if b.Base != nil {
+		return *b.Base
+	}
+	if version, ok := b.knownVersions().get(b.BlobID); ok {
+		return version
+	}
+	return FingerprintOf(b.Meta)
+}
+
+// uploadConflictCopy saves a body conflicting with the remote version of the blob as a new blob next to it,
+// leaving the remote version untouched. The entry then describes the remote version, and the copy shows up in the
+// listing of its directory under its own name. Further writes through the entry are saved as conflict copies too,
+// until the remote version is read.
+func (b *FileBlobEntry) uploadConflictCopy(ctx context.Context, remoteMeta payload.BlobMeta, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption) error {
+	meta := b.uploadMeta(ctx, src)
+	meta.Name = conflictName(b.Meta.Name, hostname())
+	if b.ParentID != "" {
+		meta.Parents = []string{b.ParentID}
+	}
+
+	if _, _, err := b.upload(ctx, "", in, src, meta, options); err != nil {
+		return err
+	}
+
+	fs.Logf(nil, "%q was changed by another client, local changes were saved as %q", b.Remote(), meta.Name)
+
+	b.Meta = remoteMeta
+	b.Base = nil
+	b.changed()
+	return nil
+}
+
+// conflictName returns the name of the conflict copy of a file, e.g. "notes (conflict from laptop).txt".
+func conflictName(name string, host string) string {
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i:]
+	}
+	return fmt.Sprintf("%s (conflict from %s)%s", base, host, ext)
+}
+
+func hostname() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	return "unknown host"
+}
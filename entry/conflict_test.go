@@ -0,0 +1,109 @@
+package entry
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/menmos/menmos-go"
+	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+)
+
+func TestFingerprintMatches(t *testing.T) {
+	base := Fingerprint{Size: 5, ModTime: "1", SHA256: "abc"}
+
+	for _, tc := range []struct {
+		other    Fingerprint
+		expected bool
+	}{
+		{Fingerprint{Size: 5, ModTime: "1", SHA256: "abc"}, true},
+		// Hashes win over modification times.
+		{Fingerprint{Size: 5, ModTime: "2", SHA256: "abc"}, true},
+		{Fingerprint{Size: 5, ModTime: "1", SHA256: "def"}, false},
+		// Without a hash on one side, modification times are compared.
+		{Fingerprint{Size: 5, ModTime: "1"}, true},
+		{Fingerprint{Size: 5, ModTime: "2"}, false},
+		{Fingerprint{Size: 6, ModTime: "1", SHA256: "abc"}, false},
+	} {
+		if matches := base.Matches(tc.other); matches != tc.expected {
+			t.Errorf("expected %+v matching %+v to be %v", base, tc.other, tc.expected)
+		}
+	}
+}
+
+func TestBaseVersion(t *testing.T) {
+	meta := payload.BlobMeta{Size: 5, Metadata: map[string]string{ModTimeMetaKey: "3"}}
+	versions := NewKnownVersions(10)
+	file := NewFile("blob", meta, "file", nil, versionsProvider{versions: versions})
+
+	if base := file.BaseVersion(); base != FingerprintOf(meta) {
+		t.Errorf("expected the listed version, got %+v", base)
+	}
+
+	known := Fingerprint{Size: 4, ModTime: "2"}
+	versions.set("blob", known)
+	if base := file.BaseVersion(); base != known {
+		t.Errorf("expected the known version, got %+v", base)
+	}
+
+	file.Base = &Fingerprint{Size: 3, ModTime: "1"}
+	if base := file.BaseVersion(); base != *file.Base {
+		t.Errorf("expected the version of the entry, got %+v", base)
+	}
+}
+
+type versionsProvider struct {
+	fs.Info
+	versions *KnownVersions
+}
+
+func (p versionsProvider) KnownVersions() *KnownVersions {
+	return p.versions
+}
+
+func TestConflictName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"notes.txt":      "notes (conflict from host).txt",
+		"archive.tar.gz": "archive.tar (conflict from host).gz",
+		"README":         "README (conflict from host)",
+		".bashrc":        ".bashrc (conflict from host)",
+	} {
+		if actual := conflictName(name, "host"); actual != expected {
+			t.Errorf("expected '%s', got '%s'", expected, actual)
+		}
+	}
+}
+
+func TestConflictCopyLeavesTheEntryOnTheRemoteVersion(t *testing.T) {
+	remoteMeta := payload.BlobMeta{Name: "notes.txt", Size: 6, Metadata: map[string]string{ModTimeMetaKey: "2"}}
+	fake := &fakeCluster{meta: remoteMeta}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := menmos.New(server.URL, "user", "password")
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+
+	listedMeta := payload.BlobMeta{Name: "notes.txt", Size: 5, Metadata: map[string]string{ModTimeMetaKey: "1"}}
+	file := NewFile("blob", listedMeta, "notes.txt", cluster.NewClient(client, cluster.Limits{}, cluster.RetryPolicy{}), nil)
+	src := object.NewStaticObjectInfo("notes.txt", time.Unix(3, 0), 5, true, nil, nil)
+	if err := file.Upload(context.Background(), strings.NewReader("local"), src); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(fake.created) != 1 || fake.created[0] != conflictName("notes.txt", hostname()) {
+		t.Errorf("expected a conflict copy to be created, got %v", fake.created)
+	}
+	if fake.updated {
+		t.Error("expected the remote version to be left untouched")
+	}
+	if file.BlobID != "blob" || file.Meta.Size != remoteMeta.Size {
+		t.Errorf("expected the entry to describe the remote version, got '%s' (%+v)", file.BlobID, file.Meta)
+	}
+}
@@ -1,8 +1,10 @@
 package entry
 
 import (
-	"github.com/menmos/menmos-go"
+	"context"
+
 	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
 	"github.com/rclone/rclone/fs"
 )
 
@@ -10,7 +12,7 @@ type DirectoryBlobEntry struct {
 	BlobEntry
 }
 
-func NewDirectory(blobID string, blobMeta payload.BlobMeta, path string, client *menmos.Client, fs fs.Info) *DirectoryBlobEntry {
+func NewDirectory(blobID string, blobMeta payload.BlobMeta, path string, client *cluster.Client, fs fs.Info) *DirectoryBlobEntry {
 	return &DirectoryBlobEntry{BlobEntry: BlobEntry{
 		BlobID: blobID,
 		Meta:   blobMeta,
@@ -21,7 +23,7 @@ func NewDirectory(blobID string, blobMeta payload.BlobMeta, path string, client
 }
 
 func (b *DirectoryBlobEntry) Items() int64 {
-	results, err := b.client.Query(payload.NewStructuredQuery(payload.NewExpression().AndParent(b.BlobID)).WithSize(0)) // With a size of 0 we load no document - query is faster.
+	results, err := b.client.Query(context.Background(), payload.NewStructuredQuery(payload.NewExpression().AndParent(b.BlobID)).WithSize(0)) // With a size of 0 we load no document - query is faster.
 	if err != nil {
 		// TODO: Log once we have logging.
 		return -1
@@ -33,3 +35,8 @@ func (b *DirectoryBlobEntry) Items() int64 {
 func (b *DirectoryBlobEntry) ID() string {
 	return b.BlobID
 }
+
+// Remove removes the directory from its parent, deleting it if it has no other parent.
+func (b *DirectoryBlobEntry) Remove(ctx context.Context) error {
+	return b.unlink(ctx)
+}
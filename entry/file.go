@@ -2,21 +2,27 @@ package entry
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"time"
 
 	"github.com/menmos/menmos-go"
 	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
 	"github.com/rclone/rclone/fs"
 	"github.com/rclone/rclone/fs/hash"
 )
 
 type FileBlobEntry struct {
 	BlobEntry
+
+	// Base is the version of the body the next upload replaces, if it differs from the default (see BaseVersion).
+	// Uploads are saved as conflict copies if the blob was changed by another client since.
+	Base *Fingerprint
 }
 
-func NewFile(blobID string, blobMeta payload.BlobMeta, path string, client *menmos.Client, fs fs.Info) *FileBlobEntry {
+func NewFile(blobID string, blobMeta payload.BlobMeta, path string, client *cluster.Client, fs fs.Info) *FileBlobEntry {
 	return &FileBlobEntry{BlobEntry: BlobEntry{
 		BlobID: blobID,
 		Meta:   blobMeta,
@@ -31,16 +37,53 @@ func (b *FileBlobEntry) Fs() fs.Info {
 }
 
 func (b *FileBlobEntry) Hash(ctx context.Context, ty hash.Type) (string, error) {
-	// TODO: Add hashing here if menmos supports it one day.
-	return "", nil
+	key, ok := hashMetaKeys[ty]
+	if !ok {
+		return "", hash.ErrUnsupported
+	}
+
+	// Blobs uploaded by other clients might not have a hash, in which case we return an empty string.
+	return b.Meta.Metadata[key], nil
+}
+
+// MimeType returns the content type of the blob, or an empty string if it is unknown.
+func (b *FileBlobEntry) MimeType(ctx context.Context) string {
+	return b.Meta.Metadata[MimeTypeMetaKey]
 }
 
 func (b *FileBlobEntry) Storable() bool {
 	return false
 }
 
+// SetModTime saves a new modification time along with the metadata of the cluster, so metadata changed by other
+// clients since the blob was listed is kept. The listed metadata is used while the cluster is unreachable.
 func (b *FileBlobEntry) SetModTime(ctx context.Context, t time.Time) error {
-	// TODO: implement once menmos supports mod times.
+	remoteMeta := b.Meta
+	if b.BlobID != "" {
+		var err error
+		if remoteMeta, err = b.client.GetMetadata(ctx, b.BlobID); err != nil {
+			if _, ok := b.fs.(Journal); !ok || !cluster.IsUnavailable(err) {
+				return err
+			}
+			remoteMeta = b.Meta
+		}
+	}
+
+	meta := withModTime(remoteMeta, t)
+	if err := b.updateMeta(ctx, meta); err != nil {
+		return err
+	}
+
+	// The new modification time is part of the version of blobs without a hash.
+	if version, ok := b.knownVersions().get(b.BlobID); ok {
+		version.ModTime = meta.Metadata[ModTimeMetaKey]
+		b.knownVersions().set(b.BlobID, version)
+	}
+	b.Meta = withMetadata(b.Meta, map[string]string{
+		ModTimeMetaKey:    meta.Metadata[ModTimeMetaKey],
+		ChangeTimeMetaKey: meta.Metadata[ChangeTimeMetaKey],
+	})
+	b.changed()
 	return nil
 }
 
@@ -69,7 +112,51 @@ func (b *FileBlobEntry) Open(ctx context.Context, options ...fs.OpenOption) (io.
 		rangeEnd = b.Size() - 1
 	}
 
-	return b.client.GetBody(b.BlobID, &menmos.Range{Start: rangeStart, End: rangeEnd})
+	// Overwrites of the blob are based on the version being read.
+	b.knownVersions().set(b.BlobID, FingerprintOf(b.Meta))
+
+	// Cached bodies are only read while the cluster is unreachable, since the listed metadata they are keyed by might
+	// be stale.
+	key, sum := bodyCacheKey(b.BlobID, b.Meta)
+	cache := b.bodyCache()
+	if !b.client.Online() {
+		if body, ok := cache.open(key, rangeStart, rangeEnd); ok {
+			return body, nil
+		}
+	}
+
+	var body io.ReadCloser
+	if readCache := b.readCache(); readCache != nil {
+		body = readCache.open(ctx, b.blobSource(), rangeStart, rangeEnd)
+	} else {
+		var err error
+		body, err = b.client.GetBody(ctx, b.BlobID, &menmos.Range{Start: rangeStart, End: rangeEnd})
+		if err != nil {
+			if cluster.IsUnavailable(err) {
+				if cached, ok := cache.open(key, rangeStart, rangeEnd); ok {
+					return cached, nil
+				}
+			}
+			return nil, err
+		}
+	}
+
+	if rangeStart == 0 && rangeEnd == b.Size()-1 {
+		body = cache.store(key, sum, b.Size(), body)
+	}
+	return cache.withFallback(key, rangeStart, rangeEnd, body), nil
+}
+
+// blobSource returns the version of the blob body chunks are read from.
+// Chunks are cached by version, so an updated body is never read from chunks of the previous one.
+func (b *FileBlobEntry) blobSource() blobSource {
+	version := FingerprintOf(b.Meta)
+	return blobSource{
+		client:  b.client,
+		blobID:  b.BlobID,
+		version: fmt.Sprintf("%s/%d/%s/%s", b.BlobID, version.Size, version.ModTime, version.SHA256),
+		size:    b.Size(),
+	}
 }
 
 func (b *FileBlobEntry) Update(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
@@ -85,15 +172,187 @@ func (b *FileBlobEntry) Update(ctx context.Context, in io.Reader, src fs.ObjectI
 		}
 	}
 
-	// TODO: Everything works without this for now, but implementing this update might give us some better performance.
-	return fs.ErrorNotImplemented
+	if src.Size() < 0 {
+		return errors.New("object size needs to be known to upload")
+	}
+
+	_, err := b.write(ctx, in, src, options)
+	return err
+}
+
+// Create uploads the body of a new blob using the entry metadata, and sets the blob ID of the entry.
+// It returns true if the upload was journaled to be sent later, in which case the entry has no blob ID yet.
+func (b *FileBlobEntry) Create(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (bool, error) {
+	return b.write(ctx, in, src, options)
+}
+
+// write uploads a body through the journal of the filesystem if it has one, or right away otherwise.
+// It returns true if the upload was journaled to be sent later.
+func (b *FileBlobEntry) write(ctx context.Context, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption) (bool, error) {
+	journal, ok := b.fs.(Journal)
+	if !ok {
+		return false, b.Upload(ctx, in, src, options...)
+	}
+
+	deferred, err := journal.JournalUpload(ctx, b, in, src, options)
+	if err != nil || !deferred {
+		return false, err
+	}
+
+	// The upload will be sent later, until then the entry describes the body it is waiting to send.
+	b.Meta = withoutHashes(b.uploadMeta(ctx, src))
+	return true, nil
+}
+
+// Upload sends a body to the cluster right away, bypassing the journal of the filesystem.
+// The blob is created if the entry has no blob ID yet. If the blob was changed by another client since the version
+// the upload replaces (see BaseVersion), the body is saved as a conflict copy instead (see uploadConflictCopy).
+func (b *FileBlobEntry) Upload(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	remoteMeta := b.Meta
+	if b.BlobID != "" {
+		var err error
+		if remoteMeta, err = b.client.GetMetadata(ctx, b.BlobID); err != nil {
+			return err
+		}
+	}
+
+	return b.UploadOver(ctx, remoteMeta, in, src, options...)
+}
+
+// UploadOver is Upload for callers which just fetched the metadata of the blob from the cluster.
+// The body is uploaded with the metadata of the cluster, so concurrent metadata changes are kept.
+func (b *FileBlobEntry) UploadOver(ctx context.Context, remoteMeta payload.BlobMeta, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) error {
+	if b.BlobID != "" {
+		if !b.BaseVersion().Matches(FingerprintOf(remoteMeta)) {
+			return b.uploadConflictCopy(ctx, remoteMeta, in, src, options)
+		}
+		b.Meta = remoteMeta
+	}
+
+	blobID, meta, err := b.upload(ctx, b.BlobID, in, src, b.uploadMeta(ctx, src), options)
+	if err != nil {
+		return err
+	}
+
+	b.BlobID = blobID
+	b.Meta = meta
+	b.Base = nil
+	b.knownVersions().set(blobID, FingerprintOf(meta))
+	b.changed()
+	return nil
+}
+
+// uploadMeta returns the metadata of the blob once the body of `src` is uploaded.
+// We re-send the metadata we already have so tags, parents & custom metadata are left untouched.
+func (b *FileBlobEntry) uploadMeta(ctx context.Context, src fs.ObjectInfo) payload.BlobMeta {
+	meta := withModTime(b.Meta, src.ModTime(ctx))
+	meta.Size = uint64(src.Size())
+	return meta
+}
+
+// upload sends a blob body to the cluster, creating a new blob if blobID is empty.
+// The hashes of the previous body are never sent along with the new one. The new hashes are sent with the body when
+// they are known beforehand, i.e. when the source knows them or when the body can be rewound (e.g. spooled bodies).
+// Otherwise they are computed while streaming and saved with an extra metadata update once the upload is done:
+// if that update fails, the blob is left without hashes rather than with wrong ones.
+func (b *FileBlobEntry) upload(ctx context.Context, blobID string, in io.Reader, src fs.ObjectInfo, meta payload.BlobMeta, options []fs.OpenOption) (string, payload.BlobMeta, error) {
+	hashes, err := knownHashes(ctx, in, src)
+	if err != nil {
+		return "", meta, err
+	}
+
+	mimeType, in := detectMimeType(ctx, in, src, options)
+	meta = withMetadata(meta, map[string]string{MimeTypeMetaKey: mimeType})
+	meta = withHashes(withoutHashes(meta), hashes)
+
+	hasher, err := hash.NewMultiHasherTypes(SupportedHashes)
+	if err != nil {
+		return "", meta, err
+	}
+	body := io.NopCloser(io.TeeReader(in, hasher))
+
+	if blobID == "" {
+		blobID, err = b.client.CreateBlob(ctx, body, meta)
+	} else {
+		err = b.client.UpdateBlob(ctx, blobID, body, meta)
+	}
+	if err != nil {
+		return "", meta, err
+	}
+
+	if sums := hasher.Sums(); blobID != "" && !hasHashes(meta, sums) {
+		// The body is uploaded already, failing here would only make callers upload it again.
+		hashedMeta := withHashes(withoutHashes(meta), sums)
+		if err := b.client.UpdateMeta(ctx, blobID, hashedMeta); err != nil {
+			fs.Errorf(nil, "failed to save the hashes of blob '%s': %v", blobID, err)
+			return blobID, withoutHashes(meta), nil
+		}
+		meta = hashedMeta
+	}
+
+	return blobID, meta, nil
+}
+
+// knownHashes returns the hashes of a body which are known before uploading it: the ones the source knows cheaply,
+// or all of them if the body can be rewound after hashing it.
+func knownHashes(ctx context.Context, in io.Reader, src fs.ObjectInfo) (map[hash.Type]string, error) {
+	hashes := sourceHashes(ctx, src)
+	seeker, ok := in.(io.ReadSeeker)
+	if len(hashes) == SupportedHashes.Count() || !ok {
+		return hashes, nil
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// Not every seeker can actually seek, e.g. pipes.
+		return hashes, nil
+	}
+
+	hasher, err := hash.NewMultiHasherTypes(SupportedHashes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(hasher, seeker); err != nil {
+		return nil, fmt.Errorf("failed to hash upload: %w", err)
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to rewind upload: %w", err)
+	}
+
+	return hasher.Sums(), nil
+}
+
+// sourceHashes returns the supported hashes the source object knows without reading its data again.
+func sourceHashes(ctx context.Context, src fs.ObjectInfo) map[hash.Type]string {
+	hashes := make(map[hash.Type]string)
+	if info := src.Fs(); info == nil || info.Features().SlowHash {
+		return hashes
+	}
+
+	for _, ty := range SupportedHashes.Array() {
+		if sum, err := src.Hash(ctx, ty); err == nil && sum != "" {
+			hashes[ty] = sum
+		}
+	}
+	return hashes
 }
 
 func (b *FileBlobEntry) Remove(ctx context.Context) error {
 	if b.BlobID == "" {
+		// The creation of the blob might still be waiting in the journal of the filesystem.
+		if _, ok := b.fs.(Journal); ok {
+			return b.unlink(ctx)
+		}
 		fs.Infof(nil, "delete - no blob id defined: %v", *b)
 		return nil
 	}
 
-	return b.client.Delete(b.BlobID)
+	return b.unlink(ctx)
+}
+
+// WithRemote returns a copy of the entry located at another remote path.
+func (b *FileBlobEntry) WithRemote(remote string) *FileBlobEntry {
+	entry := *b
+	entry.path = remote
+	return &entry
 }
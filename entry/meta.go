@@ -0,0 +1,110 @@
+package entry
+
+import (
+	"time"
+
+	"github.com/menmos/menmos-go/payload"
+	"github.com/rclone/rclone/fs/hash"
+)
+
+// Metadata keys reserved by the mount share this prefix, so they don't collide with the metadata of users.
+const reservedMetaKeyPrefix = "menmos-mount."
+
+// Reserved metadata keys used to store file times.
+// Times are stored as RFC3339 strings with nanosecond precision.
+const (
+	ModTimeMetaKey    = reservedMetaKeyPrefix + "mtime"
+	ChangeTimeMetaKey = reservedMetaKeyPrefix + "ctime"
+)
+
+// Reserved metadata keys used to store content hashes, as lowercase hex strings.
+const (
+	MD5MetaKey    = reservedMetaKeyPrefix + "md5"
+	SHA256MetaKey = reservedMetaKeyPrefix + "sha256"
+)
+
+// MimeTypeMetaKey is the reserved metadata key holding the content type of a blob.
+const MimeTypeMetaKey = reservedMetaKeyPrefix + "content_type"
+
+// SupportedHashes are the hashes computed when uploading blobs.
+var SupportedHashes = hash.NewHashSet(hash.MD5, hash.SHA256)
+
+var hashMetaKeys = map[hash.Type]string{
+	hash.MD5:    MD5MetaKey,
+	hash.SHA256: SHA256MetaKey,
+}
+
+// NewBlobMeta returns the metadata of a new blob with its modification & change times set.
+func NewBlobMeta(name string, blobType string, size uint64, modTime time.Time) payload.BlobMeta {
+	meta := payload.NewBlobMeta(name, blobType, size)
+	meta.Metadata[ModTimeMetaKey] = formatTime(modTime)
+	meta.Metadata[ChangeTimeMetaKey] = formatTime(time.Now())
+	return meta
+}
+
+// withMetadata returns a copy of meta with the provided metadata keys set.
+// The metadata map is copied so entries sharing it are left untouched.
+func withMetadata(meta payload.BlobMeta, values map[string]string) payload.BlobMeta {
+	metadata := make(map[string]string, len(meta.Metadata)+len(values))
+	for k, v := range meta.Metadata {
+		metadata[k] = v
+	}
+	for k, v := range values {
+		metadata[k] = v
+	}
+
+	meta.Metadata = metadata
+	return meta
+}
+
+// withModTime returns a copy of meta with an updated modification time.
+// This also bumps the change time.
+func withModTime(meta payload.BlobMeta, modTime time.Time) payload.BlobMeta {
+	return withMetadata(meta, map[string]string{
+		ModTimeMetaKey:    formatTime(modTime),
+		ChangeTimeMetaKey: formatTime(time.Now()),
+	})
+}
+
+// withHashes returns a copy of meta with the provided content hashes.
+func withHashes(meta payload.BlobMeta, hashes map[hash.Type]string) payload.BlobMeta {
+	values := make(map[string]string, len(hashes))
+	for ty, sum := range hashes {
+		if key, ok := hashMetaKeys[ty]; ok && sum != "" {
+			values[key] = sum
+		}
+	}
+	return withMetadata(meta, values)
+}
+
+// withoutHashes returns a copy of meta without content hashes, for bodies whose hashes aren't known yet.
+func withoutHashes(meta payload.BlobMeta) payload.BlobMeta {
+	meta = withMetadata(meta, nil)
+	for _, key := range hashMetaKeys {
+		delete(meta.Metadata, key)
+	}
+	return meta
+}
+
+// hasHashes returns whether meta holds exactly the provided content hashes.
+func hasHashes(meta payload.BlobMeta, hashes map[hash.Type]string) bool {
+	for ty, key := range hashMetaKeys {
+		if meta.Metadata[key] != hashes[ty] {
+			return false
+		}
+	}
+	return true
+}
+
+func getMetaTime(meta payload.BlobMeta, key string) time.Time {
+	if rawTime, ok := meta.Metadata[key]; ok {
+		if t, err := time.Parse(time.RFC3339Nano, rawTime); err == nil {
+			return t
+		}
+	}
+	return time.Unix(0, 0)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,41 @@
+package entry
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/rclone/rclone/fs"
+)
+
+// http.DetectContentType never looks at more than the first 512 bytes.
+const sniffLength = 512
+
+// detectMimeType returns the content type of an upload, along with a reader yielding the full body.
+// An explicit Content-Type header option wins, then the type known by the source, then the file extension.
+// As a last resort the first bytes of the body are sniffed.
+func detectMimeType(ctx context.Context, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption) (string, io.Reader) {
+	for _, option := range options {
+		if httpOption, ok := option.(*fs.HTTPOption); ok && strings.EqualFold(httpOption.Key, "Content-Type") {
+			return httpOption.Value, in
+		}
+	}
+
+	if mimeTyper, ok := src.(fs.MimeTyper); ok {
+		if mimeType := mimeTyper.MimeType(ctx); mimeType != "" {
+			return mimeType, in
+		}
+	}
+
+	if mimeType := mime.TypeByExtension(path.Ext(src.Remote())); mimeType != "" {
+		return mimeType, in
+	}
+
+	buffered := bufio.NewReaderSize(in, sniffLength)
+	head, _ := buffered.Peek(sniffLength) // A short read only means the body is small.
+	return http.DetectContentType(head), buffered
+}
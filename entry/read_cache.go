@@ -0,0 +1,355 @@
+package entry
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"sync"
+
+	"github.com/menmos/menmos-go"
+	"github.com/menmos/menmos-mount/cluster"
+	"github.com/pkg/errors"
+)
+
+// A ReadCache keeps recently read chunks of blob bodies in memory, and reads ahead of sequential readers.
+// Bodies are fetched by fixed-size chunks: small reads are served from a single request, readers sharing a chunk
+// share its request, and adjacent missing chunks are fetched with a single ranged request.
+// Chunks being fetched count against the capacity of the cache: readahead is cut short and readers wait for other
+// fetches to complete rather than growing the cache past it.
+type ReadCache struct {
+	chunkSize      int64
+	readAhead      int64 // In chunks.
+	capacityChunks int
+
+	mutex    sync.Mutex
+	chunks   map[chunkKey]*list.Element
+	lru      *list.List    // Of *chunk, most recently used first.
+	inFlight int           // Chunks being fetched.
+	released chan struct{} // Closed and replaced whenever fetches complete.
+
+	// getBody fetches a section of a blob body, it is replaced by tests.
+	getBody func(ctx context.Context, source blobSource, readRange *menmos.Range) (io.ReadCloser, error)
+}
+
+// NewReadCache returns a cache fetching bodies by chunks of `chunkSize` bytes, reading up to `readAhead` bytes ahead
+// of sequential readers and keeping at most `cacheSize` bytes of chunks (always enough for a full readahead window).
+func NewReadCache(chunkSize int64, readAhead int64, cacheSize int64) *ReadCache {
+	readAheadChunks := (readAhead + chunkSize - 1) / chunkSize
+	capacityChunks := int(cacheSize / chunkSize)
+	if minChunks := int(readAheadChunks) + 1; capacityChunks < minChunks {
+		capacityChunks = minChunks
+	}
+
+	return &ReadCache{
+		chunkSize:      chunkSize,
+		readAhead:      readAheadChunks,
+		capacityChunks: capacityChunks,
+		chunks:         make(map[chunkKey]*list.Element),
+		lru:            list.New(),
+		released:       make(chan struct{}),
+		getBody: func(ctx context.Context, source blobSource, readRange *menmos.Range) (io.ReadCloser, error) {
+			return source.client.GetBody(ctx, source.blobID, readRange)
+		},
+	}
+}
+
+// A chunkKey identifies a chunk of a version of a blob body.
+type chunkKey struct {
+	version string
+	index   int64
+}
+
+// A chunk is a section of a blob body, which might still be fetched.
+type chunk struct {
+	key     chunkKey
+	request *fetchRequest
+
+	done chan struct{} // Closed once data or err is set.
+	data []byte
+	err  error
+}
+
+// A fetchRequest fetches adjacent chunks. It is cancelled once every reader waiting for its chunks gave up, but
+// keeps running without readers, since readahead is fetched before anyone waits for it.
+type fetchRequest struct {
+	chunks  []*chunk
+	cancel  context.CancelFunc
+	waiters int // Protected by the mutex of the cache.
+}
+
+func (c *chunk) fetched() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// A blobSource is the blob a chunked body is read from.
+type blobSource struct {
+	client  *cluster.Client
+	blobID  string
+	version string
+	size    int64
+}
+
+// lastIndex returns the index of the last chunk of the blob.
+func (c *ReadCache) lastIndex(source blobSource) int64 {
+	return (source.size - 1) / c.chunkSize
+}
+
+// has returns whether a chunk is cached or being fetched.
+func (c *ReadCache) has(source blobSource, index int64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, ok := c.chunks[chunkKey{version: source.version, index: index}]
+	return ok
+}
+
+// get returns the data of a chunk, fetching it if needed.
+// If the cache is full of chunks being fetched, it waits for one of these fetches to complete first.
+func (c *ReadCache) get(ctx context.Context, source blobSource, index int64) ([]byte, error) {
+	key := chunkKey{version: source.version, index: index}
+
+	c.mutex.Lock()
+	for {
+		if _, ok := c.chunks[key]; ok || c.inFlight < c.capacityChunks {
+			break
+		}
+
+		released := c.released
+		c.mutex.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.mutex.Lock()
+	}
+
+	element, ok := c.chunks[key]
+	if ok {
+		c.lru.MoveToFront(element)
+	} else {
+		c.fetchLocked(source, index, 1)
+		element = c.chunks[key]
+	}
+	fetching := element.Value.(*chunk)
+	fetching.request.waiters++
+	c.mutex.Unlock()
+
+	select {
+	case <-fetching.done:
+	case <-ctx.Done():
+		c.mutex.Lock()
+		if fetching.request.waiters--; fetching.request.waiters == 0 {
+			c.cancelLocked(fetching.request)
+		}
+		c.mutex.Unlock()
+		return nil, ctx.Err()
+	}
+
+	if fetching.err != nil {
+		return nil, fetching.err
+	}
+	return fetching.data, nil
+}
+
+// prefetch starts fetching the chunks of the readahead window starting at `index` which aren't cached yet.
+// Adjacent missing chunks are fetched together. The window is cut short when the cache is full of chunks being
+// fetched.
+func (c *ReadCache) prefetch(source blobSource, index int64) {
+	last := index + c.readAhead - 1
+	if blobLast := c.lastIndex(source); last > blobLast {
+		last = blobLast
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	room := int64(c.capacityChunks - c.inFlight)
+	runStart := int64(-1)
+	for i := index; i <= last+1; i++ {
+		_, cached := c.chunks[chunkKey{version: source.version, index: i}]
+		if i <= last && !cached && room > 0 {
+			if runStart < 0 {
+				runStart = i
+			}
+			room--
+			continue
+		}
+
+		if runStart >= 0 {
+			c.fetchLocked(source, runStart, i-runStart)
+			runStart = -1
+		}
+		if room == 0 {
+			return
+		}
+	}
+}
+
+// fetchLocked adds `count` missing chunks starting at `first` to the cache, and fetches them with a single request.
+func (c *ReadCache) fetchLocked(source blobSource, first int64, count int64) {
+	// Chunks are shared between readers, so fetching them isn't tied to the reader which needed them first.
+	ctx, cancel := context.WithCancel(context.Background())
+	request := &fetchRequest{cancel: cancel}
+
+	chunks := make([]*chunk, 0, count)
+	for i := first; i < first+count; i++ {
+		fetching := &chunk{key: chunkKey{version: source.version, index: i}, request: request, done: make(chan struct{})}
+		c.chunks[fetching.key] = c.lru.PushFront(fetching)
+		chunks = append(chunks, fetching)
+	}
+	request.chunks = chunks
+	c.inFlight += len(chunks)
+	c.evictLocked()
+
+	go c.fetch(ctx, source, chunks)
+}
+
+// cancelLocked cancels a fetch nobody waits for anymore. Its chunks are dropped right away, so later readers fetch
+// them again rather than getting the error of the cancelled request.
+func (c *ReadCache) cancelLocked(request *fetchRequest) {
+	request.cancel()
+	c.dropLocked(request.chunks)
+}
+
+// dropLocked removes chunks from the cache, unless they were replaced already.
+func (c *ReadCache) dropLocked(chunks []*chunk) {
+	for _, dropped := range chunks {
+		if element, ok := c.chunks[dropped.key]; ok && element.Value == dropped {
+			c.lru.Remove(element)
+			delete(c.chunks, dropped.key)
+		}
+	}
+}
+
+func (c *ReadCache) fetch(ctx context.Context, source blobSource, chunks []*chunk) {
+	defer chunks[0].request.cancel()
+
+	start := chunks[0].key.index * c.chunkSize
+	end := (chunks[len(chunks)-1].key.index+1)*c.chunkSize - 1
+	if end >= source.size {
+		end = source.size - 1
+	}
+
+	data := make([]byte, end-start+1)
+	body, err := c.getBody(ctx, source, &menmos.Range{Start: start, End: end})
+	if err == nil {
+		// Ranged bodies send a request per read, so the whole range is read at once.
+		_, err = io.ReadFull(body, data)
+		body.Close()
+	}
+
+	if err != nil {
+		err = errors.Wrapf(err, "failed to read blob '%s' at offset %d", source.blobID, start)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err != nil {
+		// Failed chunks are dropped, so the next read fetches them again.
+		c.dropLocked(chunks)
+	}
+
+	for i, fetched := range chunks {
+		if err != nil {
+			fetched.err = err
+		} else {
+			chunkStart := int64(i) * c.chunkSize
+			chunkEnd := chunkStart + c.chunkSize
+			if chunkEnd > int64(len(data)) {
+				chunkEnd = int64(len(data))
+			}
+			fetched.data = data[chunkStart:chunkEnd]
+		}
+		close(fetched.done)
+	}
+
+	c.inFlight -= len(chunks)
+	close(c.released)
+	c.released = make(chan struct{})
+
+	// Fetched chunks can be evicted now.
+	c.evictLocked()
+}
+
+// evictLocked drops the least recently used chunks above the capacity of the cache.
+// Chunks still being fetched are kept, since readers are waiting for them: they are evicted once fetched.
+func (c *ReadCache) evictLocked() {
+	element := c.lru.Back()
+	for c.lru.Len() > c.capacityChunks && element != nil {
+		previous := element.Prev()
+		if evicted := element.Value.(*chunk); evicted.fetched() {
+			c.lru.Remove(element)
+			delete(c.chunks, evicted.key)
+		}
+		element = previous
+	}
+}
+
+// open returns a reader over the section of a blob body between `start` and `end` (inclusive).
+func (c *ReadCache) open(ctx context.Context, source blobSource, start int64, end int64) io.ReadCloser {
+	body := &chunkedBody{cache: c, ctx: ctx, source: source, position: start, end: end, lastIndex: -2}
+
+	// Readers starting right after chunks which were read already (e.g. a reader reopening its body further on)
+	// continue a sequential read.
+	if index := start / c.chunkSize; index > 0 && c.has(source, index-1) {
+		body.lastIndex = index - 1
+	}
+
+	return body
+}
+
+// A chunkedBody reads a section of a blob body through the read cache.
+// Once it moves to the next chunk, the read is considered sequential and the following chunks are read ahead.
+type chunkedBody struct {
+	cache  *ReadCache
+	ctx    context.Context
+	source blobSource
+
+	position  int64
+	end       int64
+	lastIndex int64
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) {
+	if b.position > b.end {
+		return 0, io.EOF
+	}
+
+	index := b.position / b.cache.chunkSize
+	if index != b.lastIndex {
+		if index == b.lastIndex+1 && b.cache.readAhead > 0 {
+			b.cache.prefetch(b.source, index+1)
+		}
+		b.lastIndex = index
+	}
+
+	data, err := b.cache.get(b.ctx, b.source, index)
+	if err != nil {
+		return 0, err
+	}
+
+	offset := b.position - index*b.cache.chunkSize
+	if offset >= int64(len(data)) {
+		// The blob is shorter than its metadata says.
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	data = data[offset:]
+	if remaining := b.end - b.position + 1; int64(len(data)) > remaining {
+		data = data[:remaining]
+	}
+
+	n := copy(p, data)
+	b.position += int64(n)
+	return n, nil
+}
+
+func (b *chunkedBody) Close() error {
+	return nil
+}
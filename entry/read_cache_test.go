@@ -0,0 +1,181 @@
+package entry
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/menmos/menmos-go"
+)
+
+const testBody = "0123456789abcdefghij"
+
+// A fakeBlob serves ranges of testBody once its gate is open, and records them.
+type fakeBlob struct {
+	gate chan struct{}
+
+	mutex     sync.Mutex
+	ranges    []menmos.Range
+	cancelled int
+}
+
+func newTestReadCache(chunkSize int64, readAhead int64, cacheSize int64) (*ReadCache, *fakeBlob) {
+	blob := &fakeBlob{gate: make(chan struct{})}
+	cache := NewReadCache(chunkSize, readAhead, cacheSize)
+	cache.getBody = func(ctx context.Context, source blobSource, readRange *menmos.Range) (io.ReadCloser, error) {
+		blob.mutex.Lock()
+		blob.ranges = append(blob.ranges, *readRange)
+		blob.mutex.Unlock()
+
+		select {
+		case <-blob.gate:
+		case <-ctx.Done():
+			blob.mutex.Lock()
+			blob.cancelled++
+			blob.mutex.Unlock()
+			return nil, ctx.Err()
+		}
+		return ioutil.NopCloser(strings.NewReader(testBody[readRange.Start : readRange.End+1])), nil
+	}
+	return cache, blob
+}
+
+func (b *fakeBlob) cancelledRequests() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.cancelled
+}
+
+func (b *fakeBlob) requests() []menmos.Range {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]menmos.Range(nil), b.ranges...)
+}
+
+var testSource = blobSource{blobID: "blob", version: "blob/20", size: int64(len(testBody))}
+
+func TestReadCacheCoalescesReaders(t *testing.T) {
+	cache, blob := newTestReadCache(4, 0, 64)
+
+	results := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			data, err := cache.get(context.Background(), testSource, 1)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			results <- string(data)
+		}()
+	}
+
+	for !cache.has(testSource, 1) {
+		time.Sleep(time.Millisecond)
+	}
+	close(blob.gate)
+
+	for i := 0; i < 2; i++ {
+		if data := <-results; data != "4567" {
+			t.Errorf("expected '4567', got '%s'", data)
+		}
+	}
+	if requests := blob.requests(); len(requests) != 1 {
+		t.Errorf("expected readers to share a request, got %v", requests)
+	}
+}
+
+func TestReadCachePrefetchesAdjacentChunksTogether(t *testing.T) {
+	cache, blob := newTestReadCache(4, 12, 64)
+	close(blob.gate)
+
+	if _, err := cache.get(context.Background(), testSource, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cache.prefetch(testSource, 0)
+
+	for _, index := range []int64{0, 1, 2} {
+		if _, err := cache.get(context.Background(), testSource, index); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	expected := []menmos.Range{{Start: 8, End: 11}, {Start: 0, End: 7}}
+	if requests := blob.requests(); len(requests) != 2 || requests[0] != expected[0] || requests[1] != expected[1] {
+		t.Errorf("expected requests %v, got %v", expected, requests)
+	}
+}
+
+func TestReadCacheEvictsLeastRecentlyUsedChunks(t *testing.T) {
+	cache, blob := newTestReadCache(4, 4, 8)
+	close(blob.gate)
+
+	for _, index := range []int64{0, 1, 0, 2} {
+		if _, err := cache.get(context.Background(), testSource, index); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if cache.has(testSource, 1) {
+		t.Error("expected chunk 1 to be evicted")
+	}
+	for _, index := range []int64{0, 2} {
+		if !cache.has(testSource, index) {
+			t.Errorf("expected chunk %d to be cached", index)
+		}
+	}
+}
+
+func TestReadCacheBoundsChunksBeingFetched(t *testing.T) {
+	cache, blob := newTestReadCache(4, 8, 12)
+
+	cache.prefetch(testSource, 0)
+	cache.prefetch(testSource, 3)
+	if !cache.has(testSource, 3) || cache.has(testSource, 4) {
+		t.Error("expected the readahead to be cut short once the cache is full")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.get(ctx, testSource, 4); err != context.DeadlineExceeded {
+		t.Errorf("expected the reader to wait for a fetch to complete, got %v", err)
+	}
+	if requests := blob.requests(); len(requests) != 2 {
+		t.Errorf("expected no request to be sent past the capacity of the cache, got %v", requests)
+	}
+
+	close(blob.gate)
+	if data, err := cache.get(context.Background(), testSource, 4); err != nil || string(data) != "ghij" {
+		t.Errorf("expected 'ghij', got '%s' (%v)", data, err)
+	}
+}
+
+func TestReadCacheCancelsAbandonedFetches(t *testing.T) {
+	cache, blob := newTestReadCache(4, 0, 64)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.get(ctx, testSource, 0); err != context.DeadlineExceeded {
+		t.Errorf("expected the read to time out, got %v", err)
+	}
+	if cache.has(testSource, 0) {
+		t.Error("expected the abandoned chunk to be dropped")
+	}
+
+	for deadline := time.Now().Add(time.Second); blob.cancelledRequests() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the request to be cancelled")
+		}
+	}
+
+	// A new reader fetches the chunk again.
+	close(blob.gate)
+	if data, err := cache.get(context.Background(), testSource, 0); err != nil || string(data) != "0123" {
+		t.Errorf("expected '0123', got '%s' (%v)", data, err)
+	}
+	if requests := blob.requests(); len(requests) != 2 {
+		t.Errorf("expected the chunk to be fetched again, got %v", requests)
+	}
+}
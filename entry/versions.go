@@ -0,0 +1,70 @@
+package entry
+
+import (
+	"container/list"
+	"sync"
+)
+
+// KnownVersions remembers the version of the body of the blobs last read or written through a filesystem, so an
+// upload is checked against the version its writer read rather than the one its entry was resolved with, which
+// already includes the changes of other clients when the entry is resolved right before uploading.
+// It keeps the versions of at most maxBlobs blobs, forgetting the least recently used ones.
+// A nil KnownVersions knows no version.
+type KnownVersions struct {
+	mutex    sync.Mutex
+	maxBlobs int
+	versions map[string]*list.Element
+	order    *list.List // Of *knownVersion, most recently used first.
+}
+
+type knownVersion struct {
+	blobID  string
+	version Fingerprint
+}
+
+// NewKnownVersions returns a KnownVersions remembering the versions of at most `maxBlobs` blobs.
+func NewKnownVersions(maxBlobs int) *KnownVersions {
+	return &KnownVersions{
+		maxBlobs: maxBlobs,
+		versions: make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// set records the version of a blob which was read or written.
+func (v *KnownVersions) set(blobID string, version Fingerprint) {
+	if v == nil || blobID == "" {
+		return
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if element, ok := v.versions[blobID]; ok {
+		element.Value.(*knownVersion).version = version
+		v.order.MoveToFront(element)
+		return
+	}
+
+	v.versions[blobID] = v.order.PushFront(&knownVersion{blobID: blobID, version: version})
+	for v.order.Len() > v.maxBlobs {
+		oldest := v.order.Back()
+		v.order.Remove(oldest)
+		delete(v.versions, oldest.Value.(*knownVersion).blobID)
+	}
+}
+
+// get returns the last version of a blob which was read or written, if it is known.
+func (v *KnownVersions) get(blobID string) (Fingerprint, bool) {
+	if v == nil {
+		return Fingerprint{}, false
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if element, ok := v.versions[blobID]; ok {
+		return element.Value.(*knownVersion).version, true
+	}
+	return Fingerprint{}, false
+}
@@ -0,0 +1,32 @@
+package entry
+
+import (
+	"testing"
+)
+
+func TestKnownVersionsForgetsLeastRecentlyUsedBlobs(t *testing.T) {
+	versions := NewKnownVersions(2)
+	versions.set("a", Fingerprint{Size: 1})
+	versions.set("b", Fingerprint{Size: 2})
+	versions.get("a")
+	versions.set("a", Fingerprint{Size: 3})
+	versions.set("c", Fingerprint{Size: 4})
+
+	if _, ok := versions.get("b"); ok {
+		t.Error("expected 'b' to be forgotten")
+	}
+	if version, ok := versions.get("a"); !ok || version.Size != 3 {
+		t.Errorf("expected the last version of 'a', got %+v (%v)", version, ok)
+	}
+	if _, ok := versions.get("c"); !ok {
+		t.Error("expected 'c' to be known")
+	}
+}
+
+func TestNilKnownVersions(t *testing.T) {
+	var versions *KnownVersions
+	versions.set("a", Fingerprint{Size: 1})
+	if _, ok := versions.get("a"); ok {
+		t.Error("expected a nil KnownVersions to know no version")
+	}
+}
@@ -18,8 +18,11 @@ func (e *VDirEntry) Remote() string {
 	return e.FullPath
 }
 
+// Virtual directories are not stored anywhere, they are considered created when the program starts.
+var virtualModTime = time.Now()
+
 func (e *VDirEntry) ModTime(context.Context) time.Time {
-	return time.Unix(0, 0)
+	return virtualModTime
 }
 
 func (e *VDirEntry) Size() int64 {
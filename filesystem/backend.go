@@ -0,0 +1,288 @@
+package filesystem
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/menmos/menmos-mount/cluster"
+	"github.com/menmos/menmos-mount/mountpoint"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/config/configmap"
+	"github.com/rclone/rclone/fs/config/configstruct"
+)
+
+// Register the menmos filesystem as an rclone backend so that regular rclone commands can target a menmos cluster.
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "menmos",
+		Description: "Menmos Cluster",
+		NewFs:       newFsFromRegistry,
+		CommandHelp: commandHelp,
+		Options: []fs.Option{{
+			Name:     "profile",
+			Help:     "Name of the menmos client profile used to connect to the cluster.",
+			Required: true,
+		}, {
+			Name:     "mount",
+			Help:     "Mount specification as JSON.\n\nThis is the same object as the \"mount\" key of mount.json.",
+			Required: true,
+		}, {
+			Name:     "spool_directory",
+			Help:     "Directory where uploads of unknown size are buffered.\n\nDefaults to the system temp directory.",
+			Advanced: true,
+		}, {
+			Name:     "max_spool_size",
+			Help:     "Maximum size of an upload of unknown size.",
+			Default:  fs.SizeSuffix(defaultMaxSpoolSize),
+			Advanced: true,
+		}, {
+			Name:     "listing_cache_ttl",
+			Help:     "How long query results are reused for listings and path resolution.\n\nA negative value disables the listing cache.",
+			Default:  fs.Duration(mountpoint.DefaultListingCacheTTL),
+			Advanced: true,
+		}, {
+			Name:     "listing_cache_size",
+			Help:     "Maximum number of query results kept in the listing cache.",
+			Default:  mountpoint.DefaultListingCacheSize,
+			Advanced: true,
+		}, {
+			Name:     "path_cache_size",
+			Help:     "Maximum number of path to blob ID mappings kept by each mount.",
+			Default:  mountpoint.DefaultPathCacheSize,
+			Advanced: true,
+		}, {
+			Name:     "query_page_size",
+			Help:     "Number of results fetched by each query request.",
+			Default:  mountpoint.DefaultQueryPageSize,
+			Advanced: true,
+		}, {
+			Name:     "query_concurrency",
+			Help:     "Maximum number of pages of a query fetched concurrently.",
+			Default:  mountpoint.DefaultQueryConcurrency,
+			Advanced: true,
+		}, {
+			Name:     "max_concurrent_requests",
+			Help:     "Maximum number of requests waiting for a response from the cluster.\n\nUploads aren't counted, since their body might be read from the cluster too.\n0 means no limit.",
+			Default:  0,
+			Advanced: true,
+		}, {
+			Name:     "queries_per_second",
+			Help:     "Maximum number of queries and metadata reads per second.\n\n0 means no limit.",
+			Default:  0.0,
+			Advanced: true,
+		}, {
+			Name:     "metadata_updates_per_second",
+			Help:     "Maximum number of metadata updates and deletions per second.\n\n0 means no limit.",
+			Default:  0.0,
+			Advanced: true,
+		}, {
+			Name:     "transfers_per_second",
+			Help:     "Maximum number of body uploads and downloads per second.\n\n0 means no limit.",
+			Default:  0.0,
+			Advanced: true,
+		}, {
+			Name:     "retry_max_attempts",
+			Help:     "Maximum number of times an idempotent request is sent when the cluster returns transient errors.",
+			Default:  cluster.DefaultMaxAttempts,
+			Advanced: true,
+		}, {
+			Name:     "retry_initial_backoff",
+			Help:     "Upper bound of the delay before the first retry, doubled with each attempt.",
+			Default:  fs.Duration(cluster.DefaultInitialBackoff),
+			Advanced: true,
+		}, {
+			Name:     "retry_max_backoff",
+			Help:     "Maximum delay between two attempts of a request.",
+			Default:  fs.Duration(cluster.DefaultMaxBackoff),
+			Advanced: true,
+		}, {
+			Name:     "breaker_threshold",
+			Help:     "Number of consecutive transient failures after which requests fail fast.\n\nA negative value disables the circuit breaker.",
+			Default:  cluster.DefaultBreakerThreshold,
+			Advanced: true,
+		}, {
+			Name:     "breaker_cooldown",
+			Help:     "How long requests fail fast before a request is allowed through again.",
+			Default:  fs.Duration(cluster.DefaultBreakerCooldown),
+			Advanced: true,
+		}, {
+			Name:     "body_cache_size",
+			Help:     "Maximum size of the blob bodies cached on disk, to be read while the cluster is unreachable.\n\n0 disables the body cache.",
+			Default:  fs.SizeSuffix(0),
+			Advanced: true,
+		}, {
+			Name:     "body_cache_directory",
+			Help:     "Directory where blob bodies are cached.\n\nDefaults to the user cache directory.",
+			Advanced: true,
+		}, {
+			Name:     "read_chunk_size",
+			Help:     "Size of the chunks blob bodies are read by.",
+			Default:  fs.SizeSuffix(defaultReadChunkSize),
+			Advanced: true,
+		}, {
+			Name:     "read_ahead",
+			Help:     "How far ahead of sequential readers chunks are fetched.\n\nSet to off to disable readahead.",
+			Default:  fs.SizeSuffix(defaultReadAhead),
+			Advanced: true,
+		}, {
+			Name:     "read_cache_size",
+			Help:     "Maximum size of the chunks kept in memory.\n\nSet to off to read bodies with one ranged request per read.",
+			Default:  fs.SizeSuffix(defaultReadCacheSize),
+			Advanced: true,
+		}, {
+			Name:     "health_check_interval",
+			Help:     "How often the cluster is checked while it is unreachable.",
+			Default:  fs.Duration(defaultHealthCheckInterval),
+			Advanced: true,
+		}, {
+			Name:     "stats_interval",
+			Help:     "How often the statistics of the mount are logged.\n\n0 disables periodic statistics logs.",
+			Default:  fs.Duration(0),
+			Advanced: true,
+		}, {
+			Name:     "persistent_cache",
+			Help:     "Save the path and listing caches in the user cache directory so they survive restarts.",
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name:     "journal",
+			Help:     "Record changes on disk before sending them, and replay them if the cluster is unreachable.\n\nUploads are copied to the journal directory before being sent.",
+			Default:  false,
+			Advanced: true,
+		}, {
+			Name:     "journal_directory",
+			Help:     "Directory of the journal (defaults to the user cache directory).",
+			Advanced: true,
+		}},
+	})
+}
+
+// backendOptions holds the configuration of a registered menmos remote.
+type backendOptions struct {
+	Profile        string        `config:"profile"`
+	Mount          string        `config:"mount"`
+	SpoolDirectory string        `config:"spool_directory"`
+	MaxSpoolSize   fs.SizeSuffix `config:"max_spool_size"`
+
+	ListingCacheTTL  fs.Duration `config:"listing_cache_ttl"`
+	ListingCacheSize int         `config:"listing_cache_size"`
+	PathCacheSize    int         `config:"path_cache_size"`
+	QueryPageSize    int         `config:"query_page_size"`
+	QueryConcurrency int         `config:"query_concurrency"`
+	PersistentCache  bool        `config:"persistent_cache"`
+
+	Journal          bool   `config:"journal"`
+	JournalDirectory string `config:"journal_directory"`
+
+	BodyCacheSize       fs.SizeSuffix `config:"body_cache_size"`
+	BodyCacheDirectory  string        `config:"body_cache_directory"`
+	HealthCheckInterval fs.Duration   `config:"health_check_interval"`
+	StatsInterval       fs.Duration   `config:"stats_interval"`
+
+	ReadChunkSize fs.SizeSuffix `config:"read_chunk_size"`
+	ReadAhead     fs.SizeSuffix `config:"read_ahead"`
+	ReadCacheSize fs.SizeSuffix `config:"read_cache_size"`
+
+	MaxConcurrentRequests    int     `config:"max_concurrent_requests"`
+	QueriesPerSecond         float64 `config:"queries_per_second"`
+	MetadataUpdatesPerSecond float64 `config:"metadata_updates_per_second"`
+	TransfersPerSecond       float64 `config:"transfers_per_second"`
+
+	RetryMaxAttempts    int         `config:"retry_max_attempts"`
+	RetryInitialBackoff fs.Duration `config:"retry_initial_backoff"`
+	RetryMaxBackoff     fs.Duration `config:"retry_max_backoff"`
+	BreakerThreshold    int         `config:"breaker_threshold"`
+	BreakerCooldown     fs.Duration `config:"breaker_cooldown"`
+}
+
+func newFsFromRegistry(ctx context.Context, name string, root string, m configmap.Mapper) (fs.Fs, error) {
+	opt := new(backendOptions)
+	if err := configstruct.Set(m, opt); err != nil {
+		return nil, err
+	}
+
+	config := Config{
+		Profile:        opt.Profile,
+		SpoolDirectory: opt.SpoolDirectory,
+		MaxSpoolSize:   int64(opt.MaxSpoolSize),
+
+		ListingCacheTTL:  opt.ListingCacheTTL,
+		ListingCacheSize: opt.ListingCacheSize,
+		PathCacheSize:    opt.PathCacheSize,
+		QueryPageSize:    opt.QueryPageSize,
+		QueryConcurrency: opt.QueryConcurrency,
+		PersistentCache:  opt.PersistentCache,
+
+		BodyCacheSize:       int64(opt.BodyCacheSize),
+		BodyCacheDirectory:  opt.BodyCacheDirectory,
+		HealthCheckInterval: opt.HealthCheckInterval,
+		StatsInterval:       opt.StatsInterval,
+
+		ReadChunkSize: int64(opt.ReadChunkSize),
+		ReadAhead:     int64(opt.ReadAhead),
+		ReadCacheSize: int64(opt.ReadCacheSize),
+
+		Journal:          opt.Journal,
+		JournalDirectory: opt.JournalDirectory,
+
+		Limits: cluster.Limits{
+			MaxConcurrentRequests:    opt.MaxConcurrentRequests,
+			QueriesPerSecond:         opt.QueriesPerSecond,
+			MetadataUpdatesPerSecond: opt.MetadataUpdatesPerSecond,
+			TransfersPerSecond:       opt.TransfersPerSecond,
+		},
+		Retry: cluster.RetryPolicy{
+			MaxAttempts:      opt.RetryMaxAttempts,
+			InitialBackoff:   opt.RetryInitialBackoff,
+			MaxBackoff:       opt.RetryMaxBackoff,
+			BreakerThreshold: opt.BreakerThreshold,
+			BreakerCooldown:  opt.BreakerCooldown,
+		},
+	}
+	if err := json.Unmarshal([]byte(opt.Mount), &config.Mount); err != nil {
+		return nil, errors.Wrap(err, "failed to parse mount specification")
+	}
+
+	f, err := newFs(ctx, name, root, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the root points to a file, rclone expects the parent directory and ErrorIsFile.
+	if f.root != "" {
+		if _, ok := f.mount.ResolveBlobFile(ctx, f.root); ok {
+			f.root = strings.TrimPrefix(path.Dir(f.root), ".")
+			return f, fs.ErrorIsFile
+		}
+	}
+
+	return f, nil
+}
+
+var commandHelp = []fs.CommandHelp{{
+	Name:  "stats",
+	Short: "Show statistics about the mount caches.",
+	Long: `This returns the size and hit/miss/eviction counters of the path caches of the mount,
+whether the mount is degraded because the cluster is unreachable, the number of changes
+waiting in the journal, and the number of journaled changes the cluster refused.
+
+Statistics are those of the process running the command, so to inspect a running mount,
+start it with --rc and query it remotely:
+
+    rclone rc backend/command command=stats fs=menmos:
+
+The stats_interval option also logs them periodically.
+`,
+}}
+
+// Command runs one of the backend commands listed in commandHelp.
+func (f *Filesystem) Command(ctx context.Context, name string, arg []string, opt map[string]string) (interface{}, error) {
+	switch name {
+	case "stats":
+		return f.Stats(), nil
+	}
+	return nil, fs.ErrorCommandNotFound
+}
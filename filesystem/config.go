@@ -1,6 +1,10 @@
 package filesystem
 
-import "github.com/menmos/menmos-go"
+import (
+	"github.com/menmos/menmos-go"
+	"github.com/menmos/menmos-mount/cluster"
+	"github.com/rclone/rclone/fs"
+)
 
 // A Config regroups configuration options.
 type Config struct {
@@ -8,4 +12,48 @@ type Config struct {
 	Profile    string                 `json:"profile"`
 	Mountpoint string                 `json:"mount_point"`
 	Mount      map[string]interface{} `json:"mount"`
+
+	// SpoolDirectory is where uploads of unknown size are buffered (defaults to the system temp directory).
+	SpoolDirectory string `json:"spool_directory,omitempty"`
+	// MaxSpoolSize is the largest upload of unknown size accepted, in bytes.
+	MaxSpoolSize int64 `json:"max_spool_size,omitempty"`
+
+	// ListingCacheTTL is how long query results are reused for listings and path resolution.
+	// A negative value disables the listing cache.
+	ListingCacheTTL fs.Duration `json:"listing_cache_ttl,omitempty"`
+	// ListingCacheSize is the maximum number of query results kept in the listing cache.
+	ListingCacheSize int `json:"listing_cache_size,omitempty"`
+	// PathCacheSize is the maximum number of path to blob ID mappings kept by each mount.
+	PathCacheSize int `json:"path_cache_size,omitempty"`
+	// QueryPageSize is the number of results fetched by each query request.
+	QueryPageSize int `json:"query_page_size,omitempty"`
+	// QueryConcurrency is the maximum number of pages of a query fetched concurrently.
+	QueryConcurrency int `json:"query_concurrency,omitempty"`
+	// Limits bound the load put on the cluster.
+	Limits cluster.Limits `json:"limits,omitempty"`
+	// Retry is how requests failing because of transient cluster errors are retried.
+	Retry cluster.RetryPolicy `json:"retry,omitempty"`
+	// BodyCacheSize is the maximum size of the blob bodies cached on disk to be read while the cluster is unreachable,
+	// in bytes. The body cache is disabled unless it is positive.
+	BodyCacheSize int64 `json:"body_cache_size,omitempty"`
+	// BodyCacheDirectory is where blob bodies are cached (defaults to the user cache directory).
+	BodyCacheDirectory string `json:"body_cache_directory,omitempty"`
+	// ReadChunkSize is the size of the chunks bodies are read by, in bytes.
+	ReadChunkSize int64 `json:"read_chunk_size,omitempty"`
+	// ReadAhead is how far ahead of sequential readers chunks are fetched, in bytes. A negative value disables it.
+	ReadAhead int64 `json:"read_ahead,omitempty"`
+	// ReadCacheSize is the maximum size of the chunks kept in memory, in bytes.
+	// A negative value disables the read cache, so every read is a ranged request.
+	ReadCacheSize int64 `json:"read_cache_size,omitempty"`
+	// HealthCheckInterval is how often the cluster is checked while it is unreachable.
+	HealthCheckInterval fs.Duration `json:"health_check_interval,omitempty"`
+	// StatsInterval is how often the statistics of the filesystem are logged. They aren't logged periodically if zero.
+	StatsInterval fs.Duration `json:"stats_interval,omitempty"`
+	// PersistentCache saves the path and listing caches in the user cache directory, so they survive restarts.
+	PersistentCache bool `json:"persistent_cache,omitempty"`
+	// Journal records uploads, moves & deletions on disk before sending them, so changes made while the cluster is
+	// unreachable (or interrupted by a crash) are replayed instead of being lost. Uploads are copied to disk first.
+	Journal bool `json:"journal,omitempty"`
+	// JournalDirectory is where the journal is kept (defaults to the user cache directory).
+	JournalDirectory string `json:"journal_directory,omitempty"`
 }
@@ -2,13 +2,16 @@ package filesystem
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"io"
+	"path"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/menmos/menmos-go"
 	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
 	"github.com/menmos/menmos-mount/entry"
 	"github.com/menmos/menmos-mount/mountpoint"
 	"github.com/rclone/rclone/fs"
@@ -17,36 +20,130 @@ import (
 
 // Filesystem provides access to a menmos cluster.
 type Filesystem struct {
-	name  string
-	mount mountpoint.MountPoint
-
-	Client *menmos.Client
+	name      string
+	root      string
+	mount     mountpoint.MountPoint
+	listings  *mountpoint.ListingCache
+	cache     *persistentCache
+	bodyCache *entry.BodyCache
+	readCache *entry.ReadCache
+	versions  *entry.KnownVersions
+	journal   *journal
+
+	background     context.Context
+	stopBackground context.CancelFunc
+
+	spoolDirectory string
+	maxSpoolSize   int64
+
+	Client *cluster.Client
 }
 
+// Overwrites are checked against the versions of the blobs most recently read or written, up to this many blobs.
+const defaultKnownVersions = 10000
+
 func NewFs(ctx context.Context, config Config) (fs.Fs, error) {
-	var client *menmos.Client
+	return newFs(ctx, "menmos", "", config)
+}
+
+// newFs creates a filesystem named `name` exposing the mount tree starting at `root`.
+func newFs(ctx context.Context, name string, root string, config Config) (*Filesystem, error) {
+	var menmosClient *menmos.Client
 	var err error
 	if config.Client == nil {
-		client, err = menmos.NewFromProfile(config.Profile)
+		menmosClient, err = menmos.NewFromProfile(config.Profile)
 		if err != nil {
 			return nil, err
 		}
 	} else {
-		client = config.Client
+		menmosClient = config.Client
+	}
+
+	// Every request sent to the cluster goes through the limits and retry policy.
+	client := cluster.NewClient(menmosClient, config.Limits, config.Retry)
+
+	maxSpoolSize := config.MaxSpoolSize
+	if maxSpoolSize <= 0 {
+		maxSpoolSize = defaultMaxSpoolSize
+	}
+
+	listingCacheTTL := time.Duration(config.ListingCacheTTL)
+	if listingCacheTTL == 0 {
+		listingCacheTTL = mountpoint.DefaultListingCacheTTL
+	}
+
+	listingCacheSize := config.ListingCacheSize
+	if listingCacheSize <= 0 {
+		listingCacheSize = mountpoint.DefaultListingCacheSize
 	}
 
 	f := &Filesystem{
-		name:   "menmos",
-		Client: client,
+		name:           name,
+		root:           strings.Trim(root, "/"),
+		listings:       mountpoint.NewListingCache(listingCacheTTL, listingCacheSize),
+		readCache:      newReadCache(config),
+		versions:       entry.NewKnownVersions(defaultKnownVersions),
+		spoolDirectory: config.SpoolDirectory,
+		maxSpoolSize:   maxSpoolSize,
+		Client:         client,
 	}
 
-	mount, err := mountpoint.Load(config.Mount, client, f)
+	mountOptions := mountpoint.Options{
+		Listings:      f.listings,
+		PathCacheSize: config.PathCacheSize,
+
+		QueryPageSize:    config.QueryPageSize,
+		QueryConcurrency: config.QueryConcurrency,
+	}
+
+	mount, err := mountpoint.Load(config.Mount, client, f, mountOptions)
 	if err != nil {
 		return nil, err
 	}
 
 	f.mount = mount
 
+	f.bodyCache, err = newBodyCache(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.PersistentCache {
+		f.cache, err = newPersistentCache(config, mount, f.listings)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Journal {
+		directory, err := journalDirectory(config)
+		if err != nil {
+			return nil, err
+		}
+
+		f.journal, err = openJournal(directory)
+		if err == errJournalLocked {
+			// Sharing the journal would mix up the changes of both processes.
+			fs.Logf(nil, "journal %q is used by another process, changes are sent without being journaled", directory)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	healthCheckInterval := time.Duration(config.HealthCheckInterval)
+	if healthCheckInterval <= 0 {
+		healthCheckInterval = defaultHealthCheckInterval
+	}
+
+	f.background, f.stopBackground = context.WithCancel(context.Background())
+	go f.monitorHealth(f.background, healthCheckInterval)
+	if f.journal != nil {
+		go f.replayLoop(f.background, healthCheckInterval)
+	}
+	if statsInterval := time.Duration(config.StatsInterval); statsInterval > 0 {
+		go f.logStatsLoop(f.background, statsInterval)
+	}
+
 	return f, nil
 }
 
@@ -57,28 +154,62 @@ func (f *Filesystem) Name() string {
 
 // Root returns the mounted filesystem root.
 func (f *Filesystem) Root() string {
-	return "/"
+	if f.root == "" {
+		return "/"
+	}
+	return f.root
 }
 
 // String returns a description of the FS
 func (f *Filesystem) String() string {
-	return "Menmos cluster " // TODO: Improve
+	if f.root == "" {
+		return "Menmos cluster"
+	}
+	return fmt.Sprintf("Menmos cluster at '%s'", f.root)
+}
+
+// InvalidateListing drops the cached listing of a directory blob, or every cached listing if parentID is empty.
+func (f *Filesystem) InvalidateListing(parentID string) {
+	f.listings.InvalidateParent(parentID)
+}
+
+// invalidateListings drops the cached listings of multiple directory blobs.
+func (f *Filesystem) invalidateListings(parentIDs ...string) {
+	for _, parentID := range parentIDs {
+		f.listings.InvalidateParent(parentID)
+	}
+}
+
+// absPath returns the path of a remote relative to the root of the mount tree.
+func (f *Filesystem) absPath(remote string) string {
+	return path.Join(f.root, remote)
+}
+
+// relPath returns the remote of a path of the mount tree, or false if the path is outside of the root.
+func (f *Filesystem) relPath(treePath string) (string, bool) {
+	if f.root == "" {
+		return treePath, true
+	} else if treePath == f.root {
+		return "", true
+	} else if strings.HasPrefix(treePath, f.root+"/") {
+		return strings.TrimPrefix(treePath, f.root+"/"), true
+	}
+	return "", false
 }
 
 // Precision returns the timestamp precision of the filesystem.
-// For Menmos, this is seconds.
+// Times are stored in the blob metadata with nanosecond precision.
 func (f *Filesystem) Precision() time.Duration {
-	return 1000000000
+	return time.Nanosecond
 }
 
 // Hashes returns the supported hash types of this filesystem.
 func (f *Filesystem) Hashes() hash.Set {
-	return hash.NewHashSet()
+	return entry.SupportedHashes
 }
 
 // Features returns the supported features of this filesystem.
 func (f *Filesystem) Features() *fs.Features {
-	// TODO: Actually support some features.
 	return &fs.Features{
 		CaseInsensitive:         false,
 		DuplicateFiles:          true,
@@ -91,82 +222,255 @@ func (f *Filesystem) Features() *fs.Features {
 		GetTier:                 false,
 		ServerSideAcrossConfigs: false,
 		IsLocal:                 false,
-		SlowModTime:             true,
-		SlowHash:                true,
+		SlowModTime:             false,
+		SlowHash:                false,
 
-		Move: f.Move,
+		Move:      f.Move,
+		DirMove:   f.DirMove,
+		PutStream: f.PutStream,
+		Command:   f.Command,
+		Shutdown:  f.Shutdown,
+
+		ChangeNotify: f.ChangeNotify,
 	}
 }
 
+// ChangeNotify polls the mount tree for changes made by other clients and reports them to `notify`.
+// The poll interval of the filesystem is received on `pollIntervalChan`, mounts can poll more often.
+func (f *Filesystem) ChangeNotify(ctx context.Context, notify func(string, fs.EntryType), pollIntervalChan <-chan time.Duration) {
+	go func() {
+		var ticker *time.Ticker
+		var tickerC <-chan time.Time
+		var pollInterval time.Duration
+
+		stopTicker := func() {
+			if ticker != nil {
+				ticker.Stop()
+				ticker, tickerC = nil, nil
+			}
+		}
+		defer stopTicker()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case interval, ok := <-pollIntervalChan:
+				if !ok {
+					return
+				}
+
+				stopTicker()
+				pollInterval = interval
+
+				tick := interval
+				if mountInterval := f.mount.PollInterval(); mountInterval > 0 && (tick <= 0 || mountInterval < tick) {
+					tick = mountInterval
+				}
+				if tick > 0 {
+					ticker = time.NewTicker(tick)
+					tickerC = ticker.C
+				}
+			case <-tickerC:
+				if !f.Client.Online() {
+					// Polling would fail, changes will be picked up once the cluster is reachable again.
+					continue
+				}
+
+				f.mount.Poll(ctx, pollInterval, func(treePath string, entryType fs.EntryType) {
+					if remote, ok := f.relPath(treePath); ok {
+						fs.Debugf(f, "change detected on %q", remote)
+						notify(remote, entryType)
+					}
+				})
+			}
+		}
+	}()
+}
+
+// Shutdown stops the background tasks of the filesystem and saves the persistent cache, if enabled.
+// Changes waiting in the journal stay on disk, and are replayed on the next start.
+func (f *Filesystem) Shutdown(ctx context.Context) error {
+	f.stopBackground()
+
+	var err error
+	if f.journal != nil {
+		err = f.journal.close()
+	}
+	if f.cache != nil {
+		if cacheErr := f.cache.Close(); err == nil {
+			err = cacheErr
+		}
+	}
+	return err
+}
+
+// BodyCache returns the cache of blob bodies, or nil if bodies aren't cached.
+func (f *Filesystem) BodyCache() *entry.BodyCache {
+	return f.bodyCache
+}
+
+// KnownVersions returns the versions of the blobs read or written through the filesystem, which overwrites are
+// based on.
+func (f *Filesystem) KnownVersions() *entry.KnownVersions {
+	return f.versions
+}
+
+// ReadCache returns the cache of body chunks, or nil if bodies are read with plain ranged requests.
+func (f *Filesystem) ReadCache() *entry.ReadCache {
+	return f.readCache
+}
+
 func (f *Filesystem) List(ctx context.Context, dir string) (entries fs.DirEntries, err error) {
-	entries, err = f.mount.ListEntries(ctx, dir, dir)
-	return
+	var pending []journalRecord
+	if f.journal != nil {
+		pending = f.journal.pendingDirectories(dir)
+	}
+
+	entries, err = f.mount.ListEntries(ctx, f.absPath(dir), dir)
+	if err != nil {
+		if _, ok := f.pendingDirectory(dir); !ok {
+			return nil, err
+		}
+		// The directory waits to be created, only the directories waiting to be created in it are known.
+		entries, err = nil, nil
+	}
+
+	// Directories waiting to be created in the journal are listed along with the directories of the cluster.
+	listed := make(map[string]bool, len(entries))
+	for _, dirEntry := range entries {
+		listed[dirEntry.Remote()] = true
+	}
+	for _, record := range pending {
+		if !listed[record.Remote] {
+			entries = append(entries, entry.NewDirectory(pendingDirectoryID(record.Seq), record.Meta, record.Remote, f.Client, f))
+		}
+	}
+	return entries, nil
+}
+
+// resolveParent resolves the directory of `remote`, which might still wait to be created in the journal.
+func (f *Filesystem) resolveParent(ctx context.Context, remote string) (*entry.DirectoryBlobEntry, bool) {
+	if directory, ok := f.mount.ResolveBlobDirectory(ctx, filepath.Dir(f.absPath(remote))); ok {
+		return directory, true
+	}
+	return f.pendingDirectory(parentRemote(remote))
+}
+
+// pendingDirectory returns the directory waiting to be created in the journal at `remote`, if any.
+// Its blob ID is a placeholder, which the changes to its children are journaled with.
+func (f *Filesystem) pendingDirectory(remote string) (*entry.DirectoryBlobEntry, bool) {
+	if f.journal == nil {
+		return nil, false
+	}
+
+	record, ok := f.journal.pendingDirectory(remote)
+	if !ok {
+		return nil, false
+	}
+	return entry.NewDirectory(pendingDirectoryID(record.Seq), record.Meta, remote, f.Client, f), true
 }
 
+// NewObject finds the Object at remote.
+// It returns fs.ErrorObjectNotFound if it can't be found and fs.ErrorNotAFile if remote is a directory.
 func (f *Filesystem) NewObject(ctx context.Context, remote string) (fs.Object, error) {
-	// TODO: In normal filesystem use, this isn't called. Not sure this is actually required for our use case.
-	// Implement this if it causes problems down the line.
-	return nil, fs.ErrorNotImplemented
+	if file, ok := f.mount.ResolveBlobFile(ctx, f.absPath(remote)); ok {
+		// Entries resolved through a virtual mount have a path relative to their sub-mount,
+		// so we rebuild the entry with the remote rclone asked for.
+		return file.WithRemote(remote), nil
+	}
+
+	if _, ok := f.mount.ResolveBlobDirectory(ctx, f.absPath(remote)); ok {
+		return nil, fs.ErrorNotAFile
+	}
+
+	return nil, fs.ErrorObjectNotFound
 }
 
 func (f *Filesystem) Put(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
 	fs.Infof(nil, "received PUT request for %s", src.Remote())
 	objectSize := src.Size()
 	if objectSize == -1 {
-		fs.Infof(nil, "PUT failed")
-		return nil, errors.New("object size needs to be known to upload")
+		return f.PutStream(ctx, in, src, options...)
 	}
 
 	// To put the object, we first need the blob ID of its parent directory.
 	// TODO: Put is called for updates AND creations - distinguish the two before uploading.
-	if parentDirectory, ok := f.mount.ResolveBlobDirectory(filepath.Dir(src.Remote())); ok {
+	if parentDirectory, ok := f.resolveParent(ctx, src.Remote()); ok {
 		fs.Infof(nil, "found parent blob: %s", parentDirectory.BlobID)
 
-		if currentFile, ok := f.mount.ResolveBlobFile(src.Remote()); ok {
+		if currentFile, ok := f.mount.ResolveBlobFile(ctx, f.absPath(src.Remote())); ok {
 			// Update
-			currentFile.Meta.Size = uint64(src.Size())
-			if err := f.Client.UpdateBlob(currentFile.BlobID, io.NopCloser(in), currentFile.Meta); err != nil {
+			if err := currentFile.Update(ctx, in, src, options...); err != nil {
 				return nil, err
 			}
 			return currentFile, nil
 		}
 		// Create
-		meta := payload.NewBlobMeta(filepath.Base(src.Remote()), "File", uint64(objectSize))
+		meta := entry.NewBlobMeta(filepath.Base(src.Remote()), "File", uint64(objectSize), src.ModTime(ctx))
 		meta.Parents = append(meta.Parents, parentDirectory.BlobID)
-		blobID, err := f.Client.CreateBlob(io.NopCloser(in), meta)
+		file := entry.NewFile("", meta, src.Remote(), f.Client, f)
+		file.ParentID = parentDirectory.BlobID
+		deferred, err := file.Create(ctx, in, src, options...)
 		if err != nil {
 			fs.Infof(nil, "PUT failed: %s", err.Error())
 			return nil, err
 		}
-		fs.Infof(nil, "PUT success: %s", blobID)
-		return entry.NewFile(blobID, meta, src.Remote(), f.Client, f), nil
+		f.mount.Invalidate(f.absPath(src.Remote()))
+		if deferred {
+			fs.Infof(nil, "PUT journaled: %s", src.Remote())
+		} else {
+			fs.Infof(nil, "PUT success: %s", file.BlobID)
+		}
+		return file, nil
 	}
 
 	fs.Infof(nil, "permission denied")
 	return nil, fs.ErrorPermissionDenied
 }
 
+// PutStream uploads an object of unknown size.
+// The body is spooled to a temporary file first since menmos needs to know the size of a blob before receiving it.
+func (f *Filesystem) PutStream(ctx context.Context, in io.Reader, src fs.ObjectInfo, options ...fs.OpenOption) (fs.Object, error) {
+	spooled, err := spool(in, f.spoolDirectory, f.maxSpoolSize)
+	if err != nil {
+		return nil, err
+	}
+	defer spooled.Close()
+
+	return f.Put(ctx, spooled, &spooledObjectInfo{ObjectInfo: src, size: spooled.size}, options...)
+}
+
 func (f *Filesystem) Mkdir(ctx context.Context, dir string) error {
 	fs.Infof(nil, "received MKDIR for: %s", dir)
 
-	if _, fileOk := f.mount.ResolveBlobFile(dir); fileOk {
+	if _, fileOk := f.mount.ResolveBlobFile(ctx, f.absPath(dir)); fileOk {
 		return fs.ErrorIsFile
 	}
 
-	if _, dirOk := f.mount.ResolveBlobDirectory(dir); dirOk {
+	if _, dirOk := f.mount.ResolveBlobDirectory(ctx, f.absPath(dir)); dirOk {
+		return fs.ErrorDirExists
+	}
+	if _, pendingOk := f.pendingDirectory(dir); pendingOk {
 		return fs.ErrorDirExists
 	}
 
-	if parentDirectory, ok := f.mount.ResolveBlobDirectory(filepath.Dir(dir)); ok {
+	if parentDirectory, ok := f.resolveParent(ctx, dir); ok {
 		fs.Infof(nil, "found new parent blob: %s", parentDirectory.BlobID)
-		meta := payload.NewBlobMeta(filepath.Base(dir), "Directory", 0)
+		meta := entry.NewBlobMeta(filepath.Base(dir), "Directory", 0, time.Now())
 		meta.Parents = append(meta.Parents, parentDirectory.BlobID)
-		blobID, err := f.Client.CreateBlob(nil, meta)
+		deferred, err := f.journalMkdir(ctx, dir, parentDirectory.BlobID, meta)
 		if err != nil {
 			return err
 		}
+		f.InvalidateListing(parentDirectory.BlobID)
+		f.mount.InvalidateTree(f.absPath(dir))
 
-		fs.Infof(nil, "PUT success: %s", blobID)
+		if deferred {
+			fs.Infof(nil, "MKDIR journaled: %s", dir)
+		} else {
+			fs.Infof(nil, "MKDIR success: %s", dir)
+		}
 		return nil
 	}
 
@@ -174,13 +478,20 @@ func (f *Filesystem) Mkdir(ctx context.Context, dir string) error {
 }
 
 func (f *Filesystem) Rmdir(ctx context.Context, dir string) error {
-	parentEntry, ok := f.mount.ResolveBlobDirectory(dir)
+	if f.journal != nil {
+		// A directory which isn't created yet is removed by never creating it.
+		if pending, err := f.journal.cancelDirectory(ctx, dir); pending || err != nil {
+			return err
+		}
+	}
+
+	parentEntry, ok := f.mount.ResolveBlobDirectory(ctx, f.absPath(dir))
 	if !ok {
 		return fs.ErrorDirNotFound
 	}
 
 	// Make sure the directory is empty.
-	response, err := f.Client.Query(payload.NewStructuredQuery(payload.NewExpression().AndParent(parentEntry.BlobID)).WithSize(0))
+	response, err := f.Client.Query(ctx, payload.NewStructuredQuery(payload.NewExpression().AndParent(parentEntry.BlobID)).WithSize(0))
 	if err != nil {
 		return err
 	}
@@ -189,41 +500,181 @@ func (f *Filesystem) Rmdir(ctx context.Context, dir string) error {
 		return fs.ErrorDirectoryNotEmpty
 	}
 
-	return f.Client.Delete(parentEntry.BlobID)
+	if err := parentEntry.Remove(ctx); err != nil {
+		return err
+	}
+
+	f.mount.InvalidateTree(f.absPath(dir))
+	return nil
 }
 
+// Move moves the object `src` to `remote`.
+// It returns fs.ErrorCantMove if the move can't be done server-side.
 func (f *Filesystem) Move(ctx context.Context, src fs.Object, remote string) (fs.Object, error) {
-	srcParentDir, ok := f.mount.ResolveBlobDirectory(filepath.Dir(src.Remote()))
+	// The remote of the source is relative to the root of its own filesystem.
+	srcFs, ok := src.Fs().(*Filesystem)
+	if !ok {
+		return nil, fs.ErrorCantMove
+	}
+	srcPath := srcFs.absPath(src.Remote())
+
+	srcParentDir, ok := srcFs.mount.ResolveBlobDirectory(ctx, filepath.Dir(srcPath))
 	if !ok {
 		return nil, fs.ErrorCantMove
 	}
 
-	if srcFile, ok := f.mount.ResolveBlobFile(src.Remote()); ok {
-		// Delete destination path if it exists.
-		if dstFile, ok := f.mount.ResolveBlobFile(remote); ok {
-			if err := dstFile.Remove(ctx); err != nil {
-				// TODO: If delete fails, what should we do here?
-				return nil, fs.ErrorCantMove
+	if srcFile, ok := srcFs.mount.ResolveBlobFile(ctx, srcPath); ok {
+		// Renaming over an existing file (e.g. an editor saving through a temp file) replaces its contents in place.
+		if dstFile, ok := f.mount.ResolveBlobFile(ctx, f.absPath(remote)); ok {
+			if dstFile.BlobID == srcFile.BlobID {
+				return dstFile.WithRemote(remote), nil
 			}
+			return f.moveOver(ctx, srcFs, srcFile.WithRemote(src.Remote()), dstFile, remote)
 		}
 
-		if dstParentDir, ok := f.mount.ResolveBlobDirectory(filepath.Dir(remote)); ok {
-			newParents := make([]string, 0, len(srcFile.Meta.Parents))
-			newParents = append(newParents, dstParentDir.ID())
-			for _, parentID := range srcFile.Meta.Parents {
-				if parentID != srcParentDir.ID() {
-					newParents = append(newParents, parentID)
-				}
-			}
-			srcFile.Meta.Parents = newParents
+		if dstParentDir, ok := f.mount.ResolveBlobDirectory(ctx, filepath.Dir(f.absPath(remote))); ok {
+			oldParents := srcFile.Meta.Parents
+			srcFile.Meta.Parents = replaceParent(srcFile.Meta.Parents, srcParentDir.ID(), dstParentDir.ID())
 			srcFile.Meta.Name = filepath.Base(remote)
 
-			if err := f.Client.UpdateMeta(srcFile.BlobID, srcFile.Meta); err != nil {
+			if err := f.JournalUpdateMeta(ctx, &srcFile.BlobEntry, srcFile.Meta); err != nil {
 				// TODO: Log
 				return nil, err
 			}
-			return srcFile, nil
+			srcFs.invalidateListings(oldParents...)
+			f.invalidateListings(srcFile.Meta.Parents...)
+			srcFs.mount.Invalidate(srcPath)
+			f.mount.Invalidate(f.absPath(remote))
+			moved := entry.NewFile(srcFile.BlobID, srcFile.Meta, remote, f.Client, f)
+			moved.ParentID = dstParentDir.ID()
+			return moved, nil
 		}
 	}
 	return nil, fs.ErrorCantMove
 }
+
+// DirMove moves the directory at srcRemote in src to dstRemote.
+// It returns fs.ErrorCantDirMove if the move can't be done server-side and fs.ErrorDirExists if the destination exists.
+func (f *Filesystem) DirMove(ctx context.Context, src fs.Fs, srcRemote, dstRemote string) error {
+	srcFs, ok := src.(*Filesystem)
+	if !ok {
+		return fs.ErrorCantDirMove
+	}
+
+	srcPath := srcFs.absPath(srcRemote)
+	dstPath := f.absPath(dstRemote)
+
+	srcDir, ok := srcFs.mount.ResolveBlobDirectory(ctx, srcPath)
+	if !ok || srcPath == "" {
+		return fs.ErrorCantDirMove
+	}
+
+	if _, ok := f.mount.ResolveBlobDirectory(ctx, dstPath); ok {
+		return fs.ErrorDirExists
+	}
+	if _, ok := f.mount.ResolveBlobFile(ctx, dstPath); ok {
+		return fs.ErrorDirExists
+	}
+
+	srcParentDir, ok := srcFs.mount.ResolveBlobDirectory(ctx, filepath.Dir(srcPath))
+	if !ok {
+		return fs.ErrorCantDirMove
+	}
+
+	dstParentDir, ok := f.mount.ResolveBlobDirectory(ctx, filepath.Dir(dstPath))
+	if !ok {
+		return fs.ErrorCantDirMove
+	}
+
+	oldParents := srcDir.Meta.Parents
+	srcDir.Meta.Parents = replaceParent(srcDir.Meta.Parents, srcParentDir.ID(), dstParentDir.ID())
+	srcDir.Meta.Name = filepath.Base(dstPath)
+
+	if err := f.JournalUpdateMeta(ctx, &srcDir.BlobEntry, srcDir.Meta); err != nil {
+		return err
+	}
+	srcFs.invalidateListings(oldParents...)
+	f.invalidateListings(srcDir.Meta.Parents...)
+
+	// Every path below the source now resolves elsewhere.
+	srcFs.mount.InvalidateTree(srcPath)
+	f.mount.InvalidateTree(dstPath)
+
+	return nil
+}
+
+// Link makes the file at srcRemote also appear at dstRemote by adding the destination directory to the blob parents.
+// Blobs only have a single name, so both paths must share the same base name.
+func (f *Filesystem) Link(ctx context.Context, srcRemote string, dstRemote string) error {
+	srcFile, ok := f.mount.ResolveBlobFile(ctx, f.absPath(srcRemote))
+	if !ok {
+		return fs.ErrorObjectNotFound
+	}
+
+	if path.Base(srcRemote) != path.Base(dstRemote) {
+		return fs.ErrorPermissionDenied
+	}
+
+	dstPath := f.absPath(dstRemote)
+	if _, ok := f.mount.ResolveBlobFile(ctx, dstPath); ok {
+		return fs.ErrorDirExists
+	}
+
+	dstParentDir, ok := f.mount.ResolveBlobDirectory(ctx, filepath.Dir(dstPath))
+	if !ok {
+		return fs.ErrorDirNotFound
+	}
+
+	for _, parentID := range srcFile.Meta.Parents {
+		if parentID == dstParentDir.ID() {
+			return fs.ErrorDirExists
+		}
+	}
+
+	// The parents slice may be shared with cached listings, so we build a new one.
+	parents := make([]string, 0, len(srcFile.Meta.Parents)+1)
+	parents = append(parents, srcFile.Meta.Parents...)
+	srcFile.Meta.Parents = append(parents, dstParentDir.ID())
+	if err := f.JournalUpdateMeta(ctx, &srcFile.BlobEntry, srcFile.Meta); err != nil {
+		return err
+	}
+
+	f.invalidateListings(srcFile.Meta.Parents...)
+	f.mount.Invalidate(dstPath)
+	return nil
+}
+
+// replaceParent returns a copy of parents where oldParent is swapped for newParent.
+// The new parent is placed first, and other parents are left untouched.
+func replaceParent(parents []string, oldParent string, newParent string) []string {
+	newParents := make([]string, 0, len(parents))
+	newParents = append(newParents, newParent)
+	for _, parentID := range parents {
+		if parentID != oldParent && parentID != newParent {
+			newParents = append(newParents, parentID)
+		}
+	}
+	return newParents
+}
+
+// moveOver moves the body of `src` into the existing blob `dst`, and then removes `src`.
+// Writing into the destination blob instead of replacing it keeps its blob ID, tags, parents and metadata intact.
+func (f *Filesystem) moveOver(ctx context.Context, srcFs *Filesystem, src *entry.FileBlobEntry, dst *entry.FileBlobEntry, remote string) (fs.Object, error) {
+	body, err := f.Client.GetBody(ctx, src.BlobID, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	if err := dst.Update(ctx, body, src); err != nil {
+		return nil, err
+	}
+
+	if err := src.Remove(ctx); err != nil {
+		// The destination is already up to date, so the move itself succeeded.
+		fs.Errorf(nil, "failed to remove '%s' after moving it over '%s': %s", src.Remote(), remote, err.Error())
+	}
+	srcFs.mount.Invalidate(srcFs.absPath(src.Remote()))
+
+	return dst.WithRemote(remote), nil
+}
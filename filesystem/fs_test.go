@@ -0,0 +1,27 @@
+package filesystem
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReplaceParent(t *testing.T) {
+	for _, tc := range []struct {
+		parents  []string
+		expected []string
+	}{
+		{[]string{"old"}, []string{"new"}},
+		{[]string{"other", "old"}, []string{"new", "other"}},
+		// Moving a blob into a directory it is already in doesn't add the directory twice.
+		{[]string{"old", "new"}, []string{"new"}},
+		{[]string{"other"}, []string{"new", "other"}},
+	} {
+		parents := append([]string(nil), tc.parents...)
+		if actual := replaceParent(parents, "old", "new"); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("expected %v to become %v, got %v", tc.parents, tc.expected, actual)
+		}
+		if !reflect.DeepEqual(parents, tc.parents) {
+			t.Errorf("expected %v to be left untouched, got %v", tc.parents, parents)
+		}
+	}
+}
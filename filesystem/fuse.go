@@ -102,7 +102,7 @@ func doMount(VFS *vfs.VFS, mountpoint string, opt *mountlib.Options) (<-chan err
 		return nil, nil, err
 	}
 
-	filesys := mount.NewFS(VFS, opt)
+	filesys := newLinkFS(mount.NewFS(VFS, opt))
 	server := fusefs.New(c, nil)
 
 	// Serve the mount point in the background returning error to errChan
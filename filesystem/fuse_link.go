@@ -0,0 +1,154 @@
+// +build linux freebsd
+
+package filesystem
+
+import (
+	"context"
+	"path"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/menmos/menmos-mount/entry"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/cmd/mount"
+	"github.com/rclone/rclone/fs"
+)
+
+// linkFS wraps the rclone FUSE filesystem to support hard links, which menmos implements with multi-parent blobs,
+// and to report blob link counts & change times.
+// Wrapped nodes are stored in their VFS node so rclone hands the same wrapper back on every lookup.
+type linkFS struct {
+	*mount.FS
+
+	filesystem *Filesystem
+}
+
+func newLinkFS(fsys *mount.FS) *linkFS {
+	filesystem, _ := fsys.VFS.Fs().(*Filesystem)
+	return &linkFS{FS: fsys, filesystem: filesystem}
+}
+
+func (l *linkFS) Root() (fusefs.Node, error) {
+	node, err := l.FS.Root()
+	if err != nil {
+		return nil, err
+	}
+	return l.wrap(node), nil
+}
+
+func (l *linkFS) wrap(node fusefs.Node) fusefs.Node {
+	switch n := node.(type) {
+	case *mount.Dir:
+		wrapped := &linkDir{Dir: n, fsys: l}
+		n.SetSys(wrapped)
+		return wrapped
+	case *mount.File:
+		wrapped := &linkFile{File: n}
+		n.SetSys(wrapped)
+		return wrapped
+	}
+	return node
+}
+
+type linkDir struct {
+	*mount.Dir
+
+	fsys *linkFS
+}
+
+func (d *linkDir) Lookup(ctx context.Context, req *fuse.LookupRequest, resp *fuse.LookupResponse) (fusefs.Node, error) {
+	node, err := d.Dir.Lookup(ctx, req, resp)
+	if err != nil {
+		return nil, err
+	}
+	return d.fsys.wrap(node), nil
+}
+
+func (d *linkDir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fusefs.Node, fusefs.Handle, error) {
+	node, handle, err := d.Dir.Create(ctx, req, resp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return d.fsys.wrap(node), handle, nil
+}
+
+func (d *linkDir) Mknod(ctx context.Context, req *fuse.MknodRequest) (fusefs.Node, error) {
+	node, err := d.Dir.Mknod(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return d.fsys.wrap(node), nil
+}
+
+func (d *linkDir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fusefs.Node, error) {
+	node, err := d.Dir.Mkdir(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return d.fsys.wrap(node), nil
+}
+
+func (d *linkDir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fusefs.Node) error {
+	// rclone expects its own directory type as the rename target.
+	if wrapped, ok := newDir.(*linkDir); ok {
+		newDir = wrapped.Dir
+	}
+	return d.Dir.Rename(ctx, req, newDir)
+}
+
+func (d *linkDir) Link(ctx context.Context, req *fuse.LinkRequest, old fusefs.Node) (fusefs.Node, error) {
+	oldFile, ok := old.(*linkFile)
+	if !ok {
+		// Directories can't be hard linked.
+		return nil, fuse.EPERM
+	}
+
+	if d.fsys.filesystem == nil {
+		return nil, fuse.ENOSYS
+	}
+
+	if err := d.fsys.filesystem.Link(ctx, oldFile.File.Path(), path.Join(d.Dir.Path(), req.NewName)); err != nil {
+		return nil, translateLinkError(err)
+	}
+
+	// Drop the cached listing so the new entry is picked up by the lookup.
+	d.Dir.ForgetPath("", fs.EntryDirectory)
+
+	return d.Lookup(ctx, &fuse.LookupRequest{Name: req.NewName}, &fuse.LookupResponse{})
+}
+
+type linkFile struct {
+	*mount.File
+}
+
+func (f *linkFile) Attr(ctx context.Context, a *fuse.Attr) error {
+	if err := f.File.Attr(ctx, a); err != nil {
+		return err
+	}
+
+	a.Nlink = 1
+	if blobEntry, ok := f.File.DirEntry().(*entry.FileBlobEntry); ok {
+		// Each parent of a blob is a directory entry pointing to it.
+		if len(blobEntry.Meta.Parents) > 1 {
+			a.Nlink = uint32(len(blobEntry.Meta.Parents))
+		}
+
+		if _, ok := blobEntry.Meta.Metadata[entry.ChangeTimeMetaKey]; ok {
+			a.Ctime = blobEntry.ChangeTime()
+		}
+	}
+
+	return nil
+}
+
+func translateLinkError(err error) error {
+	switch errors.Cause(err) {
+	case fs.ErrorObjectNotFound, fs.ErrorDirNotFound:
+		return fuse.ENOENT
+	case fs.ErrorDirExists:
+		return fuse.EEXIST
+	case fs.ErrorPermissionDenied:
+		return fuse.EPERM
+	}
+	return err
+}
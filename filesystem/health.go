@@ -0,0 +1,50 @@
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/menmos/menmos-mount/entry"
+	"github.com/pkg/errors"
+)
+
+const defaultHealthCheckInterval = 10 * time.Second
+
+// monitorHealth checks the health of the cluster while it is unreachable, so the mount goes back online
+// (and stops serving cached data) as soon as the cluster is back.
+func (f *Filesystem) monitorHealth(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !f.Client.Online() {
+				// Failures are tracked by the client.
+				_, _ = f.Client.IsHealthy(ctx)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// newBodyCache creates the cache of blob bodies read while the cluster is unreachable, if enabled.
+func newBodyCache(config Config) (*entry.BodyCache, error) {
+	if config.BodyCacheSize <= 0 {
+		return nil, nil
+	}
+
+	directory := config.BodyCacheDirectory
+	if directory == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get the user cache directory")
+		}
+		directory = filepath.Join(cacheDir, persistentCacheDirName, "bodies")
+	}
+
+	return entry.NewBodyCache(directory, config.BodyCacheSize)
+}
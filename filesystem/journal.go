@@ -0,0 +1,916 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
+	"github.com/menmos/menmos-mount/entry"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+	"github.com/rclone/rclone/fs/object"
+)
+
+type changeKind string
+
+const (
+	uploadChange   changeKind = "upload"
+	metadataChange changeKind = "metadata"
+	unlinkChange   changeKind = "unlink"
+	mkdirChange    changeKind = "mkdir"
+)
+
+// A journalRecord is a change to a blob, as saved in the journal.
+type journalRecord struct {
+	Seq    uint64     `json:"seq"`
+	Kind   changeKind `json:"kind"`
+	Remote string     `json:"remote"`
+
+	// BlobID is empty for uploads & directories creating a new blob.
+	BlobID   string           `json:"blob_id,omitempty"`
+	ParentID string           `json:"parent_id,omitempty"`
+	Meta     payload.BlobMeta `json:"meta"`
+
+	// ModTime, Size & SHA256 describe the body of uploads, which is saved next to the record.
+	ModTime time.Time `json:"mod_time,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	SHA256  string    `json:"sha256,omitempty"`
+	// Base is the version of the body an upload replaces (see entry.FileBlobEntry.BaseVersion).
+	Base *entry.Fingerprint `json:"base,omitempty"`
+
+	// pending is true once the change couldn't be sent: it then waits for the journal to be replayed.
+	pending bool
+	// replaying is true while a pending change is being sent, replayed is closed once it is done.
+	replaying bool
+	replayed  chan struct{}
+	// created is the blob created by the replay of a creation, if any.
+	created     string
+	createdMeta payload.BlobMeta
+}
+
+// pendingDirectoryPrefix starts the placeholder IDs of directories waiting to be created in the journal.
+// Changes to their children are journaled with these IDs, which are replaced once the directory is created.
+const pendingDirectoryPrefix = "journal-"
+
+func pendingDirectoryID(seq uint64) string {
+	return fmt.Sprintf("%s%d", pendingDirectoryPrefix, seq)
+}
+
+// A journal keeps the changes made through a filesystem on disk until the cluster acknowledged them.
+// Changes are sent right away while the cluster is reachable. Once a change can't be sent, it and every change made
+// after it wait in the journal, and are replayed in order once the cluster is back (or after a restart).
+//
+// Each change is a JSON record named after its sequence number, uploads also keep their body in a ".body" file.
+// Records are only deleted once their change was sent, so a crash while sending a change replays it. Replays check
+// whether their change already reached the cluster first (see replay), so it isn't applied twice.
+// Replayed changes the cluster refuses are moved to the "dead" subdirectory, to be recovered by hand.
+// A journal is used by a single process at a time, which holds a lock on its "lock" file.
+type journal struct {
+	directory string
+	lock      *os.File
+
+	mutex       sync.Mutex
+	records     []*journalRecord // In sequence order.
+	nextSeq     uint64
+	deadLetters int
+	// boundDirectories maps the placeholder IDs of replayed directory creations to the created directories.
+	boundDirectories map[string]string
+
+	// replayMutex makes sure a single replay runs at a time, so changes are replayed in order.
+	replayMutex sync.Mutex
+}
+
+// journalDirectory returns the directory of the journal of a profile and mount spec, in the user cache directory.
+func journalDirectory(config Config) (string, error) {
+	if config.JournalDirectory != "" {
+		return config.JournalDirectory, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get the user cache directory")
+	}
+
+	key, err := cacheKey(config)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, persistentCacheDirName, "journal", key), nil
+}
+
+// errJournalLocked is returned when opening a journal used by another process.
+var errJournalLocked = errors.New("journal is used by another process")
+
+const journalLockName = "lock"
+
+// openJournal locks a journal directory, then loads the changes left in it.
+// It fails with errJournalLocked if another process uses the journal, without touching its content.
+func openJournal(directory string) (*journal, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create journal directory")
+	}
+
+	lock, err := os.OpenFile(filepath.Join(directory, journalLockName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open journal lock")
+	}
+	if err := lockJournalFile(lock); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	dirEntries, err := os.ReadDir(directory)
+	if err != nil {
+		lock.Close()
+		return nil, errors.Wrap(err, "failed to read journal directory")
+	}
+
+	j := &journal{directory: directory, lock: lock, nextSeq: 1}
+	bodies := make(map[string]bool)
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		switch {
+		case dirEntry.IsDir() || name == journalLockName:
+			continue
+		case strings.HasSuffix(name, ".json"):
+			record, err := j.load(filepath.Join(directory, name))
+			if err != nil {
+				// Records are written atomically, so an unreadable record wasn't written by us.
+				fs.Errorf(nil, "Ignoring journal record %q: %v", name, err)
+				continue
+			}
+			record.pending = true
+			j.records = append(j.records, record)
+			if record.Seq >= j.nextSeq {
+				j.nextSeq = record.Seq + 1
+			}
+		case strings.HasSuffix(name, ".body"):
+			bodies[name] = true
+		default:
+			// Bodies & records which were being written when the process stopped.
+			os.Remove(filepath.Join(directory, name))
+		}
+	}
+
+	sort.Slice(j.records, func(i, k int) bool { return j.records[i].Seq < j.records[k].Seq })
+
+	// Bodies without a record belong to changes which were sent.
+	for _, record := range j.records {
+		delete(bodies, filepath.Base(j.bodyPath(record.Seq)))
+	}
+	for name := range bodies {
+		os.Remove(filepath.Join(directory, name))
+	}
+
+	if len(j.records) > 0 {
+		fs.Logf(nil, "%d changes are waiting in the journal %q", len(j.records), directory)
+	}
+
+	if deadEntries, err := os.ReadDir(j.deadDirectory()); err == nil {
+		for _, dirEntry := range deadEntries {
+			if strings.HasSuffix(dirEntry.Name(), ".json") {
+				j.deadLetters++
+			}
+		}
+	}
+	if j.deadLetters > 0 {
+		fs.Logf(nil, "%d changes refused by the cluster are kept in %q", j.deadLetters, j.deadDirectory())
+	}
+
+	return j, nil
+}
+
+// close releases the lock of the journal. Changes left in it are replayed by the next process opening it.
+func (j *journal) close() error {
+	return j.lock.Close()
+}
+
+// deadDirectory returns the directory where changes refused by the cluster are kept.
+func (j *journal) deadDirectory() string {
+	return filepath.Join(j.directory, "dead")
+}
+
+func (j *journal) recordPath(seq uint64) string {
+	return filepath.Join(j.directory, fmt.Sprintf("%020d.json", seq))
+}
+
+func (j *journal) bodyPath(seq uint64) string {
+	return filepath.Join(j.directory, fmt.Sprintf("%020d.body", seq))
+}
+
+func (j *journal) load(recordPath string) (*journalRecord, error) {
+	rawRecord, err := os.ReadFile(recordPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var record journalRecord
+	if err := json.Unmarshal(rawRecord, &record); err != nil {
+		return nil, err
+	}
+
+	if name := filepath.Base(recordPath); name != filepath.Base(j.recordPath(record.Seq)) {
+		return nil, fmt.Errorf("record %d is saved as %q", record.Seq, name)
+	}
+	return &record, nil
+}
+
+// depth returns the number of changes in the journal which weren't acknowledged by the cluster yet.
+func (j *journal) depth() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return len(j.records)
+}
+
+// deadLetterCount returns the number of changes refused by the cluster which are kept in the dead letter directory.
+func (j *journal) deadLetterCount() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.deadLetters
+}
+
+// spoolBody copies the body of an upload to a temporary file of the journal, and flushes it to disk.
+// It returns the path of the file along with the SHA-256 of the body.
+func (j *journal) spoolBody(in io.Reader, size int64) (string, string, error) {
+	file, err := os.CreateTemp(j.directory, "body-*.tmp")
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to create journal body")
+	}
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hasher), in)
+	if err == nil && written != size {
+		err = fmt.Errorf("expected %d bytes, got %d", size, written)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", "", errors.Wrap(err, "failed to write journal body")
+	}
+
+	return file.Name(), hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// write saves a record to disk, replacing any previous version of it.
+func (j *journal) write(record *journalRecord) error {
+	rawRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(j.directory, "record-*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create journal record")
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(rawRecord)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write journal record")
+	}
+
+	return os.Rename(tmpFile.Name(), j.recordPath(record.Seq))
+}
+
+// add saves a new record (with the body spooled at `bodyPath`, if any) at the end of the journal.
+// It returns true if older changes are waiting to be replayed, in which case the new record waits behind them.
+func (j *journal) add(record *journalRecord, bodyPath string) (bool, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	record.Seq = j.nextSeq
+	j.bindLocked(record)
+	if bodyPath != "" {
+		if err := os.Rename(bodyPath, j.bodyPath(record.Seq)); err != nil {
+			os.Remove(bodyPath)
+			return false, errors.Wrap(err, "failed to save journal body")
+		}
+	}
+
+	if err := j.write(record); err != nil {
+		os.Remove(j.bodyPath(record.Seq))
+		return false, err
+	}
+	j.nextSeq++
+
+	for _, other := range j.records {
+		if other.pending {
+			record.pending = true
+			break
+		}
+	}
+	j.records = append(j.records, record)
+
+	return record.pending, nil
+}
+
+// remove deletes a record once its change was sent (or dropped).
+func (j *journal) remove(record *journalRecord) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.removeLocked(record)
+}
+
+func (j *journal) removeLocked(record *journalRecord) {
+	j.dropLocked(record)
+
+	if err := os.Remove(j.recordPath(record.Seq)); err != nil && !os.IsNotExist(err) {
+		fs.Errorf(nil, "failed to remove journal record %d: %v", record.Seq, err)
+	}
+	os.Remove(j.bodyPath(record.Seq))
+}
+
+// dropLocked removes a record from the records in memory.
+func (j *journal) dropLocked(record *journalRecord) {
+	j.endReplayLocked(record)
+
+	for i, other := range j.records {
+		if other == record {
+			j.records = append(j.records[:i], j.records[i+1:]...)
+			return
+		}
+	}
+}
+
+// bury moves a record refused by the cluster, along with its body, to the dead letter directory.
+// If it can't be moved, the record stays on disk and is replayed again on the next start.
+func (j *journal) bury(record *journalRecord) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.dropLocked(record)
+
+	deadDirectory := j.deadDirectory()
+	if err := os.MkdirAll(deadDirectory, 0700); err != nil {
+		fs.Errorf(nil, "failed to create journal dead letter directory: %v", err)
+		return
+	}
+
+	// The body goes first, so a record in the dead letter directory always has its body next to it.
+	bodyPath := j.bodyPath(record.Seq)
+	if err := os.Rename(bodyPath, filepath.Join(deadDirectory, filepath.Base(bodyPath))); err != nil && !os.IsNotExist(err) {
+		fs.Errorf(nil, "failed to move journal body %d to the dead letter directory: %v", record.Seq, err)
+		return
+	}
+
+	recordPath := j.recordPath(record.Seq)
+	if err := os.Rename(recordPath, filepath.Join(deadDirectory, filepath.Base(recordPath))); err != nil {
+		fs.Errorf(nil, "failed to move journal record %d to the dead letter directory: %v", record.Seq, err)
+		return
+	}
+
+	j.deadLetters++
+}
+
+// postpone marks a record as waiting to be replayed.
+func (j *journal) postpone(record *journalRecord) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	record.pending = true
+	j.endReplayLocked(record)
+}
+
+// endReplayLocked wakes up the callers waiting for the replay of a record, if it was being replayed.
+func (j *journal) endReplayLocked(record *journalRecord) {
+	if record.replaying {
+		record.replaying = false
+		close(record.replayed)
+	}
+}
+
+// next returns the oldest record waiting to be replayed, if any, and marks it as being replayed.
+func (j *journal) next() *journalRecord {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	for _, record := range j.records {
+		if record.pending {
+			record.replaying = true
+			record.replayed = make(chan struct{})
+			return record
+		}
+	}
+	return nil
+}
+
+// pendingCreation returns the waiting change of `kind` creating a blob at `remote`, if any.
+func (j *journal) pendingCreation(kind changeKind, remote string) *journalRecord {
+	for i := len(j.records) - 1; i >= 0; i-- {
+		record := j.records[i]
+		if record.pending && record.Kind == kind && record.BlobID == "" && record.Remote == remote {
+			return record
+		}
+	}
+	return nil
+}
+
+// awaitCreationLocked returns the waiting change of `kind` creating a blob at `remote`, if any.
+// If that change is being replayed, it waits for the replay first: if the replay created the blob, the replayed
+// record is returned as `created` instead. The mutex of the journal is released while waiting.
+func (j *journal) awaitCreationLocked(ctx context.Context, kind changeKind, remote string) (waiting *journalRecord, created *journalRecord, err error) {
+	for {
+		record := j.pendingCreation(kind, remote)
+		if record == nil || !record.replaying {
+			return record, nil, nil
+		}
+
+		replayed := record.replayed
+		j.mutex.Unlock()
+		select {
+		case <-replayed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		j.mutex.Lock()
+
+		if err != nil {
+			return nil, nil, err
+		}
+		if record.created != "" {
+			return nil, record, nil
+		}
+	}
+}
+
+// amendCreation replaces the metadata of a blob which is waiting to be created at the path of `e`.
+// It returns false if no creation is waiting. If the creation was being replayed, `e` is bound to the created blob.
+func (j *journal) amendCreation(ctx context.Context, e *entry.BlobEntry, meta payload.BlobMeta, modTime time.Time) (bool, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	record, created, err := j.awaitCreationLocked(ctx, uploadChange, e.Remote())
+	if err != nil {
+		return false, err
+	}
+	if created != nil {
+		e.BlobID, e.Meta = created.created, created.createdMeta
+	}
+	if record == nil {
+		return false, nil
+	}
+
+	amended := *record
+	amended.Meta = meta
+	amended.ModTime = modTime
+	if err := j.write(&amended); err != nil {
+		return true, err
+	}
+
+	record.Meta = meta
+	record.ModTime = modTime
+	return true, nil
+}
+
+// cancelCreation drops the waiting creation of a blob at the path of `e`, if any.
+// If the creation was being replayed, `e` is bound to the created blob instead.
+func (j *journal) cancelCreation(ctx context.Context, e *entry.BlobEntry) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	record, created, err := j.awaitCreationLocked(ctx, uploadChange, e.Remote())
+	if err != nil {
+		return err
+	}
+	if created != nil {
+		e.BlobID, e.Meta = created.created, created.createdMeta
+	}
+	if record != nil {
+		j.removeLocked(record)
+	}
+	return nil
+}
+
+// pendingDirectory returns a copy of the creation of a directory waiting to be replayed at `remote`, if any.
+func (j *journal) pendingDirectory(remote string) (journalRecord, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if record := j.pendingCreation(mkdirChange, remote); record != nil {
+		return *record, true
+	}
+	return journalRecord{}, false
+}
+
+// pendingDirectories returns copies of the creations of directories in `parent` waiting to be replayed.
+func (j *journal) pendingDirectories(parent string) []journalRecord {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	var directories []journalRecord
+	for _, record := range j.records {
+		if record.pending && record.Kind == mkdirChange && record.BlobID == "" && parentRemote(record.Remote) == parent {
+			directories = append(directories, *record)
+		}
+	}
+	return directories
+}
+
+// cancelDirectory drops the waiting creation of an empty directory at `remote`.
+// It returns false if no creation is waiting, e.g. because its replay just created the directory, and fails with
+// fs.ErrorDirectoryNotEmpty if changes to children of the directory are waiting as well.
+func (j *journal) cancelDirectory(ctx context.Context, remote string) (bool, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	record, _, err := j.awaitCreationLocked(ctx, mkdirChange, remote)
+	if err != nil || record == nil {
+		return false, err
+	}
+
+	placeholderID := pendingDirectoryID(record.Seq)
+	for _, other := range j.records {
+		if other.ParentID == placeholderID || hasParent(other.Meta, placeholderID) {
+			return true, fs.ErrorDirectoryNotEmpty
+		}
+	}
+
+	j.removeLocked(record)
+	return true, nil
+}
+
+// bindDirectory replaces the placeholder ID of a replayed directory creation by the ID of the created directory, in
+// the changes waiting behind it.
+func (j *journal) bindDirectory(record *journalRecord) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.boundDirectories == nil {
+		j.boundDirectories = make(map[string]string)
+	}
+	j.boundDirectories[pendingDirectoryID(record.Seq)] = record.created
+
+	for _, other := range j.records {
+		if other != record && j.bindLocked(other) {
+			if err := j.write(other); err != nil {
+				fs.Errorf(nil, "failed to save journal record %d: %v", other.Seq, err)
+			}
+		}
+	}
+}
+
+// bindLocked replaces the placeholder IDs of replayed directory creations in a record.
+// It returns whether the record changed.
+func (j *journal) bindLocked(record *journalRecord) bool {
+	if len(j.boundDirectories) == 0 {
+		return false
+	}
+
+	changed := false
+	if blobID, ok := j.boundDirectories[record.BlobID]; ok {
+		record.BlobID, changed = blobID, true
+	}
+	if parentID, ok := j.boundDirectories[record.ParentID]; ok {
+		record.ParentID, changed = parentID, true
+	}
+
+	// The parents are copied, since they might be shared with the entry which made the change.
+	parents := make([]string, len(record.Meta.Parents))
+	for i, parentID := range record.Meta.Parents {
+		parents[i] = parentID
+		if blobID, ok := j.boundDirectories[parentID]; ok {
+			parents[i], changed = blobID, true
+		}
+	}
+	record.Meta.Parents = parents
+
+	return changed
+}
+
+func hasParent(meta payload.BlobMeta, parentID string) bool {
+	for _, other := range meta.Parents {
+		if other == parentID {
+			return true
+		}
+	}
+	return false
+}
+
+// parentRemote returns the remote of the directory of `remote`, which is empty for the root.
+func parentRemote(remote string) string {
+	if parent := path.Dir(remote); parent != "." {
+		return parent
+	}
+	return ""
+}
+
+// submit records a change, then sends it with `send` unless older changes are waiting to be replayed.
+// Changes failing because the cluster is unreachable wait to be replayed, in which case submit returns true.
+func (j *journal) submit(ctx context.Context, record *journalRecord, bodyPath string, send func(context.Context) error) (bool, error) {
+	queued, err := j.add(record, bodyPath)
+	if err != nil {
+		return false, err
+	}
+
+	if queued {
+		fs.Infof(nil, "journal: %s of %q waits for older changes", record.Kind, record.Remote)
+		return true, nil
+	}
+
+	if err := send(ctx); err != nil {
+		if cluster.IsUnavailable(err) {
+			fs.Logf(nil, "journal: %s of %q will be sent once the cluster is reachable: %v", record.Kind, record.Remote, err)
+			j.postpone(record)
+			return true, nil
+		}
+
+		j.remove(record)
+		return false, err
+	}
+
+	j.remove(record)
+	return false, nil
+}
+
+// JournalUpload records the body of a file upload, then sends it.
+// Uploads are sent right away if the filesystem has no journal.
+func (f *Filesystem) JournalUpload(ctx context.Context, file *entry.FileBlobEntry, in io.Reader, src fs.ObjectInfo, options []fs.OpenOption) (bool, error) {
+	if f.journal == nil {
+		return false, file.Upload(ctx, in, src, options...)
+	}
+
+	bodyPath, sum, err := f.journal.spoolBody(in, src.Size())
+	if err != nil {
+		return false, err
+	}
+
+	if file.BlobID == "" {
+		// The new body replaces any upload of the same file which is still waiting, or is uploaded over the blob
+		// it created if it was being replayed.
+		if err := f.journal.cancelCreation(ctx, &file.BlobEntry); err != nil {
+			os.Remove(bodyPath)
+			return false, err
+		}
+	}
+
+	record := &journalRecord{
+		Kind:     uploadChange,
+		Remote:   file.Remote(),
+		BlobID:   file.BlobID,
+		ParentID: file.ParentID,
+		Meta:     file.Meta,
+		ModTime:  src.ModTime(ctx),
+		Size:     src.Size(),
+		SHA256:   sum,
+	}
+	if file.BlobID != "" {
+		// The entry is gone by the time the upload is replayed, so it can't tell which version it replaces anymore.
+		base := file.BaseVersion()
+		record.Base = &base
+	}
+
+	return f.journal.submit(ctx, record, bodyPath, func(ctx context.Context) error {
+		body, err := os.Open(f.journal.bodyPath(record.Seq))
+		if err != nil {
+			return errors.Wrap(err, "failed to open journal body")
+		}
+		defer body.Close()
+
+		return file.Upload(ctx, body, src, options...)
+	})
+}
+
+// JournalUpdateMeta records a metadata update, then sends it.
+// Updates are sent right away if the filesystem has no journal.
+func (f *Filesystem) JournalUpdateMeta(ctx context.Context, e *entry.BlobEntry, meta payload.BlobMeta) error {
+	if f.journal == nil {
+		return f.Client.UpdateMeta(ctx, e.BlobID, meta)
+	}
+
+	if e.BlobID == "" {
+		// The blob doesn't exist yet: it will be created with the new metadata.
+		modTime := (&entry.BlobEntry{Meta: meta}).ModTime(ctx)
+		if ok, err := f.journal.amendCreation(ctx, e, meta, modTime); ok || err != nil {
+			return err
+		}
+		if e.BlobID == "" {
+			return fs.ErrorObjectNotFound
+		}
+		// The replay created the blob in the meantime, its metadata is updated like any other.
+	}
+
+	record := &journalRecord{Kind: metadataChange, Remote: e.Remote(), BlobID: e.BlobID, Meta: meta}
+	_, err := f.journal.submit(ctx, record, "", func(ctx context.Context) error {
+		return f.Client.UpdateMeta(ctx, e.BlobID, meta)
+	})
+	return err
+}
+
+// JournalUnlink records the removal of an entry from its parent directory, then sends it.
+// Removals are sent right away if the filesystem has no journal.
+func (f *Filesystem) JournalUnlink(ctx context.Context, e *entry.BlobEntry) error {
+	if f.journal == nil {
+		return e.Unlink(ctx)
+	}
+
+	if e.BlobID == "" {
+		// The blob doesn't exist yet, so we only have to make sure it is never created. If the replay created it in
+		// the meantime, it is removed like any other.
+		if err := f.journal.cancelCreation(ctx, e); err != nil || e.BlobID == "" {
+			return err
+		}
+	}
+
+	// The parents of the blob are only read when the removal is sent, so a replayed removal never deletes a blob
+	// which was linked in another directory in the meantime.
+	record := &journalRecord{Kind: unlinkChange, Remote: e.Remote(), BlobID: e.BlobID, ParentID: e.ParentID}
+	_, err := f.journal.submit(ctx, record, "", func(ctx context.Context) error {
+		return e.Unlink(ctx)
+	})
+	return err
+}
+
+// journalMkdir records the creation of a directory, then sends it.
+// It returns true if the creation waits to be replayed: until then, the directory is resolved from the journal (see
+// resolveParent) with a placeholder ID. Directories are created right away if the filesystem has no journal.
+func (f *Filesystem) journalMkdir(ctx context.Context, remote string, parentID string, meta payload.BlobMeta) (bool, error) {
+	create := func(ctx context.Context) error {
+		_, err := f.Client.CreateBlob(ctx, nil, meta)
+		return err
+	}
+
+	if f.journal == nil {
+		return false, create(ctx)
+	}
+
+	record := &journalRecord{Kind: mkdirChange, Remote: remote, ParentID: parentID, Meta: meta}
+	return f.journal.submit(ctx, record, "", create)
+}
+
+// replayJournal sends the changes waiting in the journal, in order.
+// It stops at the first change failing because the cluster is unreachable. Changes the cluster refuses are moved to
+// the dead letter directory.
+func (f *Filesystem) replayJournal(ctx context.Context) {
+	f.journal.replayMutex.Lock()
+	defer f.journal.replayMutex.Unlock()
+
+	for {
+		record := f.journal.next()
+		if record == nil {
+			return
+		}
+
+		err := f.replay(ctx, record)
+		if err != nil && (cluster.IsUnavailable(err) || ctx.Err() != nil) {
+			f.journal.postpone(record)
+			return
+		}
+
+		if err != nil {
+			fs.Errorf(nil, "journal: %s of %q refused by the cluster, keeping it in %q: %v", record.Kind, record.Remote, f.journal.deadDirectory(), err)
+			f.journal.bury(record)
+		} else {
+			fs.Infof(nil, "journal: replayed %s of %q", record.Kind, record.Remote)
+			if record.Kind == mkdirChange {
+				f.journal.bindDirectory(record)
+			}
+			f.journal.remove(record)
+		}
+
+		// Replayed changes can affect any listing.
+		f.InvalidateListing("")
+		f.mount.InvalidateTree(f.absPath(record.Remote))
+	}
+}
+
+// replay sends the change of a journal record.
+// Creations and uploads are skipped if they already reached the cluster, e.g. because the process stopped after
+// sending them but before removing their record. Other changes are idempotent.
+func (f *Filesystem) replay(ctx context.Context, record *journalRecord) error {
+	switch record.Kind {
+	case uploadChange:
+		return f.replayUpload(ctx, record)
+	case metadataChange:
+		return f.Client.UpdateMeta(ctx, record.BlobID, record.Meta)
+	case unlinkChange:
+		file := entry.NewFile(record.BlobID, record.Meta, record.Remote, f.Client, f)
+		file.ParentID = record.ParentID
+		if err := file.Unlink(ctx); err != nil && !cluster.IsNotFound(err) {
+			return err
+		}
+		return nil
+	case mkdirChange:
+		f.forgetListing(record)
+		if directory, ok := f.mount.ResolveBlobDirectory(ctx, f.absPath(record.Remote)); ok {
+			fs.Infof(nil, "journal: %s of %q was already applied", record.Kind, record.Remote)
+			record.created = directory.BlobID
+			return nil
+		}
+
+		var err error
+		record.created, err = f.Client.CreateBlob(ctx, nil, record.Meta)
+		return err
+	}
+	return fmt.Errorf("unknown change kind %q", record.Kind)
+}
+
+// replayUpload sends a journaled upload, unless the blob already has its body. The remote metadata fetched for that
+// check is the one the upload is compared with, to detect changes made by other clients in the meantime.
+func (f *Filesystem) replayUpload(ctx context.Context, record *journalRecord) error {
+	file := entry.NewFile(record.BlobID, record.Meta, record.Remote, f.Client, f)
+	file.ParentID = record.ParentID
+	file.Base = record.Base
+
+	var remoteMeta payload.BlobMeta
+	remoteID := record.BlobID
+	if record.BlobID != "" {
+		var err error
+		if remoteMeta, err = f.Client.GetMetadata(ctx, record.BlobID); err != nil {
+			return err
+		}
+	} else {
+		// A created blob is found by path.
+		f.forgetListing(record)
+		if created, ok := f.mount.ResolveBlobFile(ctx, f.absPath(record.Remote)); ok {
+			remoteMeta, remoteID = created.Meta, created.BlobID
+		}
+	}
+
+	if remoteMeta.Size == uint64(record.Size) && record.SHA256 != "" && remoteMeta.Metadata[entry.SHA256MetaKey] == record.SHA256 {
+		fs.Infof(nil, "journal: %s of %q was already applied", record.Kind, record.Remote)
+		if record.BlobID == "" {
+			record.created, record.createdMeta = remoteID, remoteMeta
+		}
+		return nil
+	}
+
+	body, err := os.Open(f.journal.bodyPath(record.Seq))
+	if err != nil {
+		return errors.Wrap(err, "failed to open journal body")
+	}
+	defer body.Close()
+
+	src := object.NewStaticObjectInfo(record.Remote, record.ModTime, record.Size, true, nil, f)
+	if err := file.UploadOver(ctx, remoteMeta, body, src); err != nil {
+		return err
+	}
+
+	if record.BlobID == "" {
+		record.created, record.createdMeta = file.BlobID, file.Meta
+	}
+	return nil
+}
+
+// forgetListing drops the cached listing of the directory of a record, so it is listed again from the cluster.
+func (f *Filesystem) forgetListing(record *journalRecord) {
+	f.InvalidateListing(record.ParentID)
+	f.mount.InvalidateTree(f.absPath(record.Remote))
+}
+
+// replayLoop replays the journal on startup, then periodically while changes are waiting and the cluster is reachable.
+func (f *Filesystem) replayLoop(ctx context.Context, interval time.Duration) {
+	f.replayJournal(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if f.journal.depth() > 0 && f.Client.Online() {
+				f.replayJournal(ctx)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// journalDepth returns the number of changes waiting in the journal, or 0 if the filesystem has no journal.
+func (f *Filesystem) journalDepth() int {
+	if f.journal == nil {
+		return 0
+	}
+	return f.journal.depth()
+}
+
+// journalDeadLetters returns the number of changes refused by the cluster kept by the journal, or 0 if the
+// filesystem has no journal.
+func (f *Filesystem) journalDeadLetters() int {
+	if f.journal == nil {
+		return 0
+	}
+	return f.journal.deadLetterCount()
+}
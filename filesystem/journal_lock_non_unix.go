@@ -0,0 +1,12 @@
+// +build !linux,!darwin,!freebsd
+
+package filesystem
+
+import (
+	"os"
+)
+
+// lockJournalFile doesn't lock anything on platforms without flock: a single process may use a journal at a time.
+func lockJournalFile(file *os.File) error {
+	return nil
+}
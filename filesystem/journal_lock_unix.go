@@ -0,0 +1,22 @@
+// +build linux darwin freebsd
+
+package filesystem
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// lockJournalFile takes an exclusive lock on the lock file of a journal, failing with errJournalLocked if another
+// process holds it. The lock is released when the file is closed, or when the process exits.
+func lockJournalFile(file *os.File) error {
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		if err == unix.EWOULDBLOCK {
+			return errJournalLocked
+		}
+		return errors.Wrap(err, "failed to lock journal")
+	}
+	return nil
+}
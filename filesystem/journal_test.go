@@ -0,0 +1,341 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/entry"
+	"github.com/rclone/rclone/fs"
+)
+
+func openTestJournal(t *testing.T, directory string) *journal {
+	t.Helper()
+
+	j, err := openJournal(directory)
+	if err != nil {
+		t.Fatalf("failed to open journal: %v", err)
+	}
+	return j
+}
+
+func spoolTestBody(t *testing.T, j *journal, body string) string {
+	t.Helper()
+
+	bodyPath, sum, err := j.spoolBody(strings.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("failed to spool body: %v", err)
+	}
+
+	expectedSum := sha256.Sum256([]byte(body))
+	if sum != hex.EncodeToString(expectedSum[:]) {
+		t.Errorf("expected the spooled body to be hashed, got '%s'", sum)
+	}
+	return bodyPath
+}
+
+func testEntry(remote string) *entry.BlobEntry {
+	return &entry.NewFile("", payload.BlobMeta{}, remote, nil, nil).BlobEntry
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// A transient error postpones journaled changes.
+var errUnreachable = io.ErrUnexpectedEOF
+
+func TestJournalReloadsRecordsInOrder(t *testing.T) {
+	directory := t.TempDir()
+	j := openTestJournal(t, directory)
+
+	var records []*journalRecord
+	for _, remote := range []string{"a", "b", "c"} {
+		record := &journalRecord{Kind: uploadChange, Remote: remote, Size: 5}
+		if _, err := j.add(record, spoolTestBody(t, j, "hello")); err != nil {
+			t.Fatalf("failed to add record: %v", err)
+		}
+		records = append(records, record)
+	}
+	j.remove(records[1])
+
+	// Leftovers of a crash: a temporary file, and a body whose record was removed.
+	os.WriteFile(filepath.Join(directory, "record-1.tmp"), []byte("{"), 0600)
+	os.WriteFile(j.bodyPath(42), []byte("orphan"), 0600)
+	j.close()
+
+	reopened := openTestJournal(t, directory)
+	if len(reopened.records) != 2 || reopened.records[0].Remote != "a" || reopened.records[1].Remote != "c" {
+		t.Fatalf("expected records a and c, got %+v", reopened.records)
+	}
+	for _, record := range reopened.records {
+		if !record.pending {
+			t.Errorf("expected reloaded record %q to wait for a replay", record.Remote)
+		}
+		if !exists(reopened.bodyPath(record.Seq)) {
+			t.Errorf("expected the body of record %q to be kept", record.Remote)
+		}
+	}
+	if reopened.nextSeq != records[2].Seq+1 {
+		t.Errorf("expected sequence numbers to continue at %d, got %d", records[2].Seq+1, reopened.nextSeq)
+	}
+	if exists(filepath.Join(directory, "record-1.tmp")) || exists(j.bodyPath(42)) {
+		t.Error("expected leftovers to be removed")
+	}
+
+	if next := reopened.next(); next == nil || next.Remote != "a" {
+		t.Errorf("expected record a to be replayed first, got %+v", next)
+	}
+}
+
+func TestJournalSubmitQueuesBehindPendingChanges(t *testing.T) {
+	j := openTestJournal(t, t.TempDir())
+	ctx := context.Background()
+
+	queued, err := j.submit(ctx, &journalRecord{Kind: metadataChange, Remote: "a"}, "", func(context.Context) error {
+		return errUnreachable
+	})
+	if err != nil || !queued {
+		t.Fatalf("expected the change to be postponed, got %v, %v", queued, err)
+	}
+
+	sent := false
+	queued, err = j.submit(ctx, &journalRecord{Kind: metadataChange, Remote: "b"}, "", func(context.Context) error {
+		sent = true
+		return nil
+	})
+	if err != nil || !queued || sent {
+		t.Errorf("expected the change to wait behind the pending one, got %v, %v (sent: %v)", queued, err, sent)
+	}
+
+	if depth := j.depth(); depth != 2 {
+		t.Errorf("expected 2 changes in the journal, got %d", depth)
+	}
+
+	first := j.next()
+	if first == nil || first.Remote != "a" {
+		t.Fatalf("expected record a to be replayed first, got %+v", first)
+	}
+	j.remove(first)
+	if second := j.next(); second == nil || second.Remote != "b" {
+		t.Errorf("expected record b to be replayed next, got %+v", second)
+	}
+}
+
+func TestJournalSubmitRemovesSentAndRefusedChanges(t *testing.T) {
+	j := openTestJournal(t, t.TempDir())
+	ctx := context.Background()
+
+	if queued, err := j.submit(ctx, &journalRecord{Kind: metadataChange}, "", func(context.Context) error { return nil }); err != nil || queued {
+		t.Errorf("expected the change to be sent, got %v, %v", queued, err)
+	}
+
+	refused := errors.New("unexpected status '400 Bad Request'")
+	if _, err := j.submit(ctx, &journalRecord{Kind: metadataChange}, "", func(context.Context) error { return refused }); err != refused {
+		t.Errorf("expected the error of the cluster, got %v", err)
+	}
+
+	if depth := j.depth(); depth != 0 {
+		t.Errorf("expected an empty journal, got %d changes", depth)
+	}
+}
+
+func TestJournalCancelCreation(t *testing.T) {
+	j := openTestJournal(t, t.TempDir())
+	ctx := context.Background()
+
+	// The creation is waiting behind an update which couldn't be sent.
+	j.submit(ctx, &journalRecord{Kind: metadataChange, Remote: "other", BlobID: "1"}, "", func(context.Context) error {
+		return errUnreachable
+	})
+	creation := &journalRecord{Kind: uploadChange, Remote: "file", Size: 5}
+	if queued, err := j.submit(ctx, creation, spoolTestBody(t, j, "hello"), nil); err != nil || !queued {
+		t.Fatalf("expected the creation to be queued, got %v, %v", queued, err)
+	}
+
+	meta := payload.BlobMeta{Name: "file", Size: 5}
+	modTime := time.Unix(1000, 0)
+	if ok, err := j.amendCreation(ctx, testEntry("file"), meta, modTime); !ok || err != nil {
+		t.Errorf("expected the creation to be amended, got %v, %v", ok, err)
+	}
+	reloaded, err := j.load(j.recordPath(creation.Seq))
+	if err != nil || reloaded.Meta.Name != "file" || !reloaded.ModTime.Equal(modTime) {
+		t.Errorf("expected the amended record to be saved, got %+v, %v", reloaded, err)
+	}
+
+	j.cancelCreation(ctx, testEntry("other"))
+	j.cancelCreation(ctx, testEntry("file"))
+	if depth := j.depth(); depth != 1 {
+		t.Errorf("expected only the update to be left, got %d changes", depth)
+	}
+	if exists(j.recordPath(creation.Seq)) || exists(j.bodyPath(creation.Seq)) {
+		t.Error("expected the cancelled creation to be removed from disk")
+	}
+
+	if ok, _ := j.amendCreation(ctx, testEntry("file"), meta, modTime); ok {
+		t.Error("expected no creation to be left to amend")
+	}
+}
+
+func TestJournalBuriesRefusedChanges(t *testing.T) {
+	directory := t.TempDir()
+	j := openTestJournal(t, directory)
+
+	record := &journalRecord{Kind: uploadChange, Remote: "file", Size: 5}
+	if _, err := j.add(record, spoolTestBody(t, j, "hello")); err != nil {
+		t.Fatalf("failed to add record: %v", err)
+	}
+
+	j.bury(record)
+
+	if depth := j.depth(); depth != 0 {
+		t.Errorf("expected an empty journal, got %d changes", depth)
+	}
+	if exists(j.recordPath(record.Seq)) || exists(j.bodyPath(record.Seq)) {
+		t.Error("expected the record to be moved out of the journal")
+	}
+
+	deadDirectory := j.deadDirectory()
+	body, err := os.ReadFile(filepath.Join(deadDirectory, filepath.Base(j.bodyPath(record.Seq))))
+	if err != nil || string(body) != "hello" {
+		t.Errorf("expected the body to be kept, got %q, %v", body, err)
+	}
+
+	j.close()
+	if count := openTestJournal(t, directory).deadLetterCount(); count != 1 {
+		t.Errorf("expected a dead letter after a restart, got %d", count)
+	}
+}
+
+func TestJournalIsUsedByASingleProcess(t *testing.T) {
+	directory := t.TempDir()
+	j := openTestJournal(t, directory)
+
+	record := &journalRecord{Kind: metadataChange, Remote: "file"}
+	if _, err := j.add(record, ""); err != nil {
+		t.Fatalf("failed to add record: %v", err)
+	}
+
+	if _, err := openJournal(directory); err != errJournalLocked {
+		t.Fatalf("expected the journal to be locked, got %v", err)
+	}
+	if !exists(j.recordPath(record.Seq)) {
+		t.Error("expected a locked journal to be left untouched")
+	}
+
+	j.close()
+	if reopened := openTestJournal(t, directory); reopened.depth() != 1 {
+		t.Errorf("expected the change to be reloaded once the journal is unlocked, got %d changes", reopened.depth())
+	}
+}
+
+func TestJournalRefusesTruncatedBodies(t *testing.T) {
+	j := openTestJournal(t, t.TempDir())
+
+	if _, _, err := j.spoolBody(strings.NewReader("hello"), 6); err == nil {
+		t.Error("expected a body shorter than its size to be refused")
+	}
+
+	entries, _ := os.ReadDir(j.directory)
+	for _, dirEntry := range entries {
+		if dirEntry.Name() != journalLockName {
+			t.Errorf("expected the refused body to be removed, found %q", dirEntry.Name())
+		}
+	}
+}
+
+func TestJournalCreationsWaitForTheirReplay(t *testing.T) {
+	j := openTestJournal(t, t.TempDir())
+	ctx := context.Background()
+
+	creation := &journalRecord{Kind: uploadChange, Remote: "file", Size: 5}
+	j.submit(ctx, creation, spoolTestBody(t, j, "hello"), func(context.Context) error { return errUnreachable })
+	if replayed := j.next(); replayed != creation {
+		t.Fatalf("expected the creation to be replayed, got %+v", replayed)
+	}
+
+	file := testEntry("file")
+	cancelled := make(chan error)
+	go func() {
+		cancelled <- j.cancelCreation(ctx, file)
+	}()
+
+	select {
+	case err := <-cancelled:
+		t.Fatalf("expected the creation being replayed to be waited for, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	creation.created = "42"
+	j.remove(creation)
+	if err := <-cancelled; err != nil || file.BlobID != "42" {
+		t.Errorf("expected the entry to be bound to the created blob, got '%s' (%v)", file.BlobID, err)
+	}
+}
+
+func TestJournalBindsPendingDirectories(t *testing.T) {
+	j := openTestJournal(t, t.TempDir())
+	ctx := context.Background()
+
+	mkdir := &journalRecord{Kind: mkdirChange, Remote: "dir"}
+	j.submit(ctx, mkdir, "", func(context.Context) error { return errUnreachable })
+
+	pending, ok := j.pendingDirectory("dir")
+	if !ok || pending.Seq != mkdir.Seq {
+		t.Fatalf("expected 'dir' to wait to be created, got %+v", pending)
+	}
+	if directories := j.pendingDirectories(""); len(directories) != 1 || directories[0].Remote != "dir" {
+		t.Errorf("expected 'dir' to be listed in the root, got %+v", directories)
+	}
+
+	placeholderID := pendingDirectoryID(mkdir.Seq)
+	child := &journalRecord{Kind: uploadChange, Remote: "dir/file", ParentID: placeholderID, Meta: payload.BlobMeta{Parents: []string{placeholderID}}}
+	if _, err := j.add(child, spoolTestBody(t, j, "hello")); err != nil {
+		t.Fatalf("failed to add record: %v", err)
+	}
+	if _, err := j.cancelDirectory(ctx, "dir"); err != fs.ErrorDirectoryNotEmpty {
+		t.Errorf("expected a directory with waiting children not to be removed, got %v", err)
+	}
+
+	j.next()
+	mkdir.created = "7"
+	j.bindDirectory(mkdir)
+	j.remove(mkdir)
+
+	reloaded, err := j.load(j.recordPath(child.Seq))
+	if err != nil || reloaded.ParentID != "7" || len(reloaded.Meta.Parents) != 1 || reloaded.Meta.Parents[0] != "7" {
+		t.Errorf("expected the child to be moved to the created directory, got %+v (%v)", reloaded, err)
+	}
+
+	// Changes made with the placeholder after the directory was created are bound as well.
+	late := &journalRecord{Kind: metadataChange, Remote: "dir", BlobID: placeholderID}
+	if _, err := j.add(late, ""); err != nil || late.BlobID != "7" {
+		t.Errorf("expected the late change to be bound to the created directory, got '%s' (%v)", late.BlobID, err)
+	}
+}
+
+func TestJournalCancelDirectory(t *testing.T) {
+	j := openTestJournal(t, t.TempDir())
+	ctx := context.Background()
+
+	j.submit(ctx, &journalRecord{Kind: mkdirChange, Remote: "dir"}, "", func(context.Context) error { return errUnreachable })
+
+	if pending, err := j.cancelDirectory(ctx, "other"); pending || err != nil {
+		t.Errorf("expected no directory to be cancelled, got %v, %v", pending, err)
+	}
+	if pending, err := j.cancelDirectory(ctx, "dir"); !pending || err != nil {
+		t.Errorf("expected the directory to be cancelled, got %v, %v", pending, err)
+	}
+	if depth := j.depth(); depth != 0 {
+		t.Errorf("expected an empty journal, got %d changes", depth)
+	}
+}
@@ -24,6 +24,9 @@ func Mount(config Config, verbose bool) (*mountlib.MountPoint, error) {
 		return nil, err
 	}
 
+	// Statistics of the mount can be logged on demand with `kill -USR1`.
+	fs.(*Filesystem).LogStatsOnSignal()
+
 	vfsOptions := getVFSOptions()
 	mountOptions := getMountLibOptions()
 
@@ -36,6 +39,8 @@ func Mount(config Config, verbose bool) (*mountlib.MountPoint, error) {
 	}
 
 	if _, err := mount.Mount(); err != nil {
+		// The error of the mount matters more than the one of the shutdown.
+		_ = fs.Features().Shutdown(context.Background())
 		return nil, err
 	}
 
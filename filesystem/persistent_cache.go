@@ -0,0 +1,170 @@
+package filesystem
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/mountpoint"
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+const persistentCacheDirName = "menmos-mount"
+
+// The persistent cache is saved periodically, so a crash loses at most this much of it.
+const persistentCacheSaveInterval = time.Minute
+
+// cacheSnapshot is the on-disk representation of the mount caches.
+type cacheSnapshot struct {
+	Paths    map[string]string                 `json:"paths"`
+	Listings map[string]*payload.QueryResponse `json:"listings"`
+}
+
+// A persistentCache saves the path and listing caches of a filesystem to disk, so warm starts don't have to walk
+// the mount tree again.
+// Restored entries are not trusted blindly: listings expire after one TTL and path mappings are replaced as soon
+// as their parent directory is listed again.
+type persistentCache struct {
+	path     string
+	mount    mountpoint.MountPoint
+	listings *mountpoint.ListingCache
+
+	saveMutex sync.Mutex
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// persistentCachePath returns the cache file of a profile and mount spec, in the user cache directory.
+func persistentCachePath(config Config) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get the user cache directory")
+	}
+
+	key, err := cacheKey(config)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, persistentCacheDirName, key+".json"), nil
+}
+
+// cacheKey identifies a profile and mount spec in the user cache directory.
+// The mount spec is part of the key since the same paths map to different blobs in another mount tree.
+func cacheKey(config Config) (string, error) {
+	rawMount, err := json.Marshal(config.Mount)
+	if err != nil {
+		return "", err
+	}
+
+	key := sha256.Sum256(append([]byte(config.Profile+"\x00"), rawMount...))
+	return hex.EncodeToString(key[:]), nil
+}
+
+// newPersistentCache restores the caches of `mount` from disk and starts saving them in the background.
+func newPersistentCache(config Config, mount mountpoint.MountPoint, listings *mountpoint.ListingCache) (*persistentCache, error) {
+	cachePath, err := persistentCachePath(config)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &persistentCache{
+		path:     cachePath,
+		mount:    mount,
+		listings: listings,
+		stop:     make(chan struct{}),
+	}
+
+	if err := c.load(); err != nil {
+		// A corrupted or unreadable cache only means a cold start.
+		fs.Logf(nil, "Ignoring persistent cache %q: %v", cachePath, err)
+	}
+
+	go c.saveLoop()
+
+	return c, nil
+}
+
+func (c *persistentCache) load() error {
+	rawSnapshot, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var snapshot cacheSnapshot
+	if err := json.Unmarshal(rawSnapshot, &snapshot); err != nil {
+		return err
+	}
+
+	c.mount.RestorePaths(snapshot.Paths)
+	c.listings.Restore(snapshot.Listings)
+
+	fs.Debugf(nil, "Restored %d paths and %d listings from %q", len(snapshot.Paths), len(snapshot.Listings), c.path)
+	return nil
+}
+
+// save writes the current content of the caches to disk.
+func (c *persistentCache) save() error {
+	c.saveMutex.Lock()
+	defer c.saveMutex.Unlock()
+
+	rawSnapshot, err := json.Marshal(cacheSnapshot{
+		Paths:    c.mount.CachedPaths(),
+		Listings: c.listings.Entries(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return errors.Wrap(err, "failed to create cache directory")
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated cache behind.
+	tmpFile, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create cache file")
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(rawSnapshot); err != nil {
+		tmpFile.Close()
+		return errors.Wrap(err, "failed to write cache file")
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return errors.Wrap(err, "failed to write cache file")
+	}
+
+	return os.Rename(tmpFile.Name(), c.path)
+}
+
+func (c *persistentCache) saveLoop() {
+	ticker := time.NewTicker(persistentCacheSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.save(); err != nil {
+				fs.Errorf(nil, "Failed to save persistent cache: %v", err)
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background saves and saves the caches one last time.
+func (c *persistentCache) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return c.save()
+}
@@ -0,0 +1,36 @@
+package filesystem
+
+import "github.com/menmos/menmos-mount/entry"
+
+// By default, bodies are read by chunks of 4MiB, up to 16MiB ahead of sequential readers.
+const (
+	defaultReadChunkSize int64 = 4 << 20
+	defaultReadAhead     int64 = 16 << 20
+	defaultReadCacheSize int64 = 64 << 20
+)
+
+// newReadCache creates the in-memory cache of body chunks, unless it is disabled.
+func newReadCache(config Config) *entry.ReadCache {
+	if config.ReadCacheSize < 0 {
+		return nil
+	}
+
+	chunkSize := config.ReadChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultReadChunkSize
+	}
+
+	readAhead := config.ReadAhead
+	if readAhead == 0 {
+		readAhead = defaultReadAhead
+	} else if readAhead < 0 {
+		readAhead = 0
+	}
+
+	cacheSize := config.ReadCacheSize
+	if cacheSize == 0 {
+		cacheSize = defaultReadCacheSize
+	}
+
+	return entry.NewReadCache(chunkSize, readAhead, cacheSize)
+}
@@ -0,0 +1,78 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/rclone/rclone/fs"
+)
+
+// By default, streamed uploads larger than 1GiB are refused.
+const defaultMaxSpoolSize int64 = 1 << 30
+
+// A spooledFile is a temporary file holding the body of an upload whose size was not known in advance.
+type spooledFile struct {
+	*os.File
+
+	size int64
+}
+
+// spool copies `in` to a temporary file in `dir` (or the system temp directory if empty),
+// failing if the stream is larger than `maxSize` bytes.
+func spool(in io.Reader, dir string, maxSize int64) (*spooledFile, error) {
+	file, err := os.CreateTemp(dir, "menmos-spool-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create spool file")
+	}
+
+	spooled := &spooledFile{File: file}
+
+	// We read one byte past the limit to detect streams that are too large.
+	size, err := io.Copy(file, io.LimitReader(in, maxSize+1))
+	if err != nil {
+		spooled.Close()
+		return nil, errors.Wrap(err, "failed to spool upload")
+	}
+
+	if size > maxSize {
+		spooled.Close()
+		return nil, fmt.Errorf("upload is larger than the maximum spool size (%d bytes)", maxSize)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, errors.Wrap(err, "failed to rewind spool file")
+	}
+
+	spooled.size = size
+	return spooled, nil
+}
+
+// Close closes and deletes the spool file.
+func (s *spooledFile) Close() error {
+	err := s.File.Close()
+	if removeErr := os.Remove(s.File.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// spooledObjectInfo describes an upload of unknown size once it is spooled: it is its original source, with the size
+// of the spooled body. Uploads hash spooled bodies before sending them since they can be rewound.
+type spooledObjectInfo struct {
+	fs.ObjectInfo
+
+	size int64
+}
+
+func (i *spooledObjectInfo) Size() int64 {
+	return i.size
+}
+
+// MimeType returns the content type known by the original source, if any.
+func (i *spooledObjectInfo) MimeType(ctx context.Context) string {
+	return fs.MimeType(ctx, i.ObjectInfo)
+}
@@ -0,0 +1,40 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	directory := t.TempDir()
+
+	spooled, err := spool(strings.NewReader("hello"), directory, 5)
+	if err != nil {
+		t.Fatalf("failed to spool: %v", err)
+	}
+	if spooled.size != 5 {
+		t.Errorf("expected a size of 5, got %d", spooled.size)
+	}
+	if body, err := ioutil.ReadAll(spooled); err != nil || string(body) != "hello" {
+		t.Errorf("expected the spooled body to be read from the start, got %q (%v)", body, err)
+	}
+
+	spooled.Close()
+	if _, err := os.Stat(spooled.Name()); !os.IsNotExist(err) {
+		t.Error("expected the spool file to be removed once closed")
+	}
+}
+
+func TestSpoolRefusesLargeUploads(t *testing.T) {
+	directory := t.TempDir()
+
+	if _, err := spool(strings.NewReader("hello!"), directory, 5); err == nil {
+		t.Error("expected an upload larger than the limit to be refused")
+	}
+
+	if entries, _ := os.ReadDir(directory); len(entries) != 0 {
+		t.Errorf("expected the spool file to be removed, found %d files", len(entries))
+	}
+}
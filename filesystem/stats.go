@@ -0,0 +1,83 @@
+package filesystem
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/menmos/menmos-mount/mountpoint"
+	"github.com/rclone/rclone/fs"
+)
+
+// Stats are the runtime statistics of a filesystem.
+type Stats struct {
+	PathCache mountpoint.PathCacheStats `json:"path_cache"`
+
+	// Degraded is true while the cluster is unreachable and the mount serves cached data.
+	Degraded bool `json:"degraded"`
+
+	// JournalDepth is the number of changes recorded in the journal which the cluster didn't acknowledge yet.
+	JournalDepth int `json:"journal_depth"`
+	// JournalDeadLetters is the number of journaled changes the cluster refused, kept in the "dead" subdirectory of the
+	// journal.
+	JournalDeadLetters int `json:"journal_dead_letters"`
+}
+
+// Stats returns the current statistics of the filesystem.
+func (f *Filesystem) Stats() Stats {
+	return Stats{
+		PathCache:          f.mount.PathCacheStats(),
+		Degraded:           !f.Client.Online(),
+		JournalDepth:       f.journalDepth(),
+		JournalDeadLetters: f.journalDeadLetters(),
+	}
+}
+
+// LogStats logs the current statistics of the filesystem.
+func (f *Filesystem) LogStats() {
+	stats, err := json.Marshal(f.Stats())
+	if err != nil {
+		fs.Errorf(f, "failed to encode statistics: %v", err)
+		return
+	}
+	fs.Logf(f, "stats: %s", stats)
+}
+
+// logStatsLoop logs the statistics of the filesystem every `interval`.
+func (f *Filesystem) logStatsLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.LogStats()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// LogStatsOnSignal logs the statistics of the filesystem whenever the process receives the stats signal
+// (SIGUSR1, on platforms which have it), until the filesystem is shut down.
+func (f *Filesystem) LogStatsOnSignal() {
+	signals := make(chan os.Signal, 1)
+	if !notifyStatsSignal(signals) {
+		return
+	}
+
+	go func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-signals:
+				f.LogStats()
+			case <-f.background.Done():
+				return
+			}
+		}
+	}()
+}
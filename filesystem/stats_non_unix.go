@@ -0,0 +1,11 @@
+// +build !linux,!darwin,!freebsd
+
+package filesystem
+
+import (
+	"os"
+)
+
+func notifyStatsSignal(signals chan<- os.Signal) bool {
+	return false
+}
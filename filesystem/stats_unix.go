@@ -0,0 +1,15 @@
+// +build linux darwin freebsd
+
+package filesystem
+
+import (
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+)
+
+func notifyStatsSignal(signals chan<- os.Signal) bool {
+	signal.Notify(signals, unix.SIGUSR1)
+	return true
+}
@@ -11,5 +11,7 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/rclone/rclone v1.56.0
 	github.com/urfave/cli/v2 v2.3.0
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	golang.org/x/sys v0.0.0-20210823070655-63515b42dcdf
+	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac
 )
@@ -1,63 +1,119 @@
 package mountpoint
 
 import (
+	"context"
 	"errors"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/menmos/menmos-go"
 	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
 	"github.com/menmos/menmos-mount/entry"
 	"github.com/rclone/rclone/fs"
+	"golang.org/x/sync/singleflight"
 )
 
 type abstractMount struct {
-	client *menmos.Client
+	client *cluster.Client
 	fs     fs.Info
 
-	cache *pathCache
+	cache   *pathCache
+	watcher *watcher
+	options Options
+
+	// inflight coalesces identical concurrent queries, keyed by their serialized query.
+	inflight singleflight.Group
+}
+
+func newAbstractMount(client *cluster.Client, fs fs.Info, options Options) *abstractMount {
+	return &abstractMount{
+		client:  client,
+		fs:      fs,
+		cache:   newPathCache(options.PathCacheSize),
+		watcher: newWatcher(options.PollInterval, options.PathCacheSize),
+		options: options,
+	}
 }
 
-func (m *abstractMount) getQueryChildrenMap(query *payload.Query) (map[string]string, error) {
-	results, err := getFullQueryResults(query, m.client)
+// query returns all results of a query.
+// Concurrent callers running the same query share a single round trip to the cluster.
+func (m *abstractMount) query(ctx context.Context, query *payload.Query) (*payload.QueryResponse, error) {
+	key, err := listingKey(query)
 	if err != nil {
 		return nil, err
 	}
 
-	hitMap := make(map[string]string)
+	resultChan := m.inflight.DoChan(key, func() (interface{}, error) {
+		return getFullQueryResults(ctx, query, m.client, m.options)
+	})
+
+	select {
+	case result := <-resultChan:
+		if result.Err != nil {
+			if isContextError(result.Err) && ctx.Err() == nil {
+				// The caller who started the query gave up on it, but we didn't.
+				return m.query(ctx, query)
+			}
+			return nil, result.Err
+		}
+		return result.Val.(*payload.QueryResponse), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
-	for _, hit := range results.Hits {
-		hitMap[hit.Metadata.Name] = hit.ID
+// getQueryChildrenMap maps the names of the results of a query to their blob IDs.
+// The results are the children of the directory at pathSegment: the path cache is refreshed accordingly.
+func (m *abstractMount) getQueryChildrenMap(ctx context.Context, query *payload.Query, pathSegment string) (map[string]string, error) {
+	results, err := m.query(ctx, query)
+	if err != nil {
+		return nil, err
 	}
 
+	hitMap := childrenMap(results)
+	m.cache.SetChildren(cleanSegment(pathSegment), hitMap)
+	m.watcher.watch(cleanSegment(pathSegment), query, results)
+
 	return hitMap, nil
 }
 
-func (m *abstractMount) getEntriesFromQuery(query *payload.Query, fullpath string) (fs.DirEntries, error) {
-	results, err := getFullQueryResults(query, m.client)
+// getEntriesFromQuery lists the results of a query as directory entries.
+// parentID is the blob ID of the directory being listed, or empty if the results aren't the children of a directory.
+// pathSegment is the path of the listed directory within the mount, used to refresh the path cache.
+func (m *abstractMount) getEntriesFromQuery(ctx context.Context, query *payload.Query, parentID string, pathSegment string, fullpath string) (fs.DirEntries, error) {
+	results, err := m.query(ctx, query)
 	if err != nil {
 		return []fs.DirEntry{}, err
 	}
 
+	m.cache.SetChildren(cleanSegment(pathSegment), childrenMap(results))
+	m.watcher.watch(cleanSegment(pathSegment), query, results)
+
 	entries := make([]fs.DirEntry, results.Count, results.Count)
 
 	for i, hit := range results.Hits {
 		if hit.Metadata.BlobType == "File" {
 			fs.Infof(nil, "file entry for blob: %s", hit.ID)
-			entries[i] = entry.NewFile(hit.ID, hit.Metadata, path.Join(fullpath, hit.Metadata.Name), m.client, m.fs)
+			file := entry.NewFile(hit.ID, hit.Metadata, path.Join(fullpath, hit.Metadata.Name), m.client, m.fs)
+			file.ParentID = parentID
+			entries[i] = file
 		} else {
 			fs.Infof(nil, "dir entry for blob: %s", hit.ID)
-			entries[i] = entry.NewDirectory(hit.ID, hit.Metadata, path.Join(fullpath, hit.Metadata.Name), m.client, m.fs)
+			dir := entry.NewDirectory(hit.ID, hit.Metadata, path.Join(fullpath, hit.Metadata.Name), m.client, m.fs)
+			dir.ParentID = parentID
+			entries[i] = dir
 		}
 	}
 
 	return entries, nil
 }
 
-func (m *abstractMount) ensurePathInCache(pathSegment string) error {
+func (m *abstractMount) ensurePathInCache(ctx context.Context, pathSegment string) error {
 	// Cache the blob IDs of all directories in the way of the path we're trying to reach.
-	// TODO: Document this part some more because its confusing as hell.
+	// We start from the deepest directory of the path that is already cached, and list our way down
+	// one directory at a time. Listing a directory caches the blob IDs of all its children.
 
 	// Walk back the cache to find the lowest cached directory in the path we're looking for (if any).
 	fs.Infof(nil, "resolving blob IDS for '%s'", pathSegment)
@@ -81,30 +137,27 @@ func (m *abstractMount) ensurePathInCache(pathSegment string) error {
 		return errors.New("no base Blob ID found")
 	}
 
-	uncachedSegment := strings.TrimPrefix(pathSegment, cachedSegment)
+	uncachedSegment := strings.TrimPrefix(strings.TrimPrefix(pathSegment, cachedSegment), "/")
 
 	fs.Infof(nil, "cachedSegment='%s', uncachedSegment='%s', lowestCachedBlobID='%s'\n", cachedSegment, uncachedSegment, lowestCachedBlobID)
 
-	for {
-		cachedBlobEntries, err := m.getQueryChildrenMap(payload.NewStructuredQuery(payload.NewExpression().AndParent(lowestCachedBlobID)))
+	for uncachedSegment != "" {
+		splitted := strings.SplitN(uncachedSegment, "/", 2)
+		entryName := splitted[0]
+
+		cachedBlobEntries, err := m.getQueryChildrenMap(ctx, payload.NewStructuredQuery(payload.NewExpression().AndParent(lowestCachedBlobID)), cachedSegment)
 		if err != nil {
 			return err
 		}
 
-		splitted := strings.SplitN(uncachedSegment, "/", 2)
-		if splitted[0] == "/" || splitted[0] == "." || splitted[0] == "" {
-			// We reached the end.
-			break
-		}
-
-		entryName := splitted[0]
-		if entryBlobID, ok := cachedBlobEntries[entryName]; ok {
-			cachedSegment = filepath.Join(cachedSegment, entryName)
-			m.cache.SetBlobID(cachedSegment, entryBlobID)
-		} else {
+		entryBlobID, ok := cachedBlobEntries[entryName]
+		if !ok {
 			return fs.ErrorDirNotFound
 		}
 
+		cachedSegment = filepath.Join(cachedSegment, entryName)
+		lowestCachedBlobID = entryBlobID
+
 		if len(splitted) == 1 {
 			break
 		}
@@ -114,3 +167,65 @@ func (m *abstractMount) ensurePathInCache(pathSegment string) error {
 
 	return nil
 }
+
+func (m *abstractMount) Invalidate(pathSegment string) {
+	m.cache.Invalidate(cleanSegment(pathSegment))
+}
+
+func (m *abstractMount) InvalidateTree(pathSegment string) {
+	m.cache.InvalidateTree(cleanSegment(pathSegment))
+	m.watcher.forgetTree(cleanSegment(pathSegment))
+}
+
+func (m *abstractMount) Poll(ctx context.Context, defaultInterval time.Duration, notify ChangeNotifyFunc) {
+	if !m.watcher.due(time.Now(), defaultInterval) {
+		return
+	}
+
+	m.watcher.poll(ctx, m.client, m.options, func(dirPath string, results *payload.QueryResponse) {
+		m.cache.SetChildren(dirPath, childrenMap(results))
+	}, notify)
+}
+
+func (m *abstractMount) PollInterval() time.Duration {
+	if m.options.PollInterval < 0 {
+		return 0
+	}
+	return m.options.PollInterval
+}
+
+func (m *abstractMount) PathCacheStats() PathCacheStats {
+	return m.cache.Stats()
+}
+
+func (m *abstractMount) CachedPaths() map[string]string {
+	return m.cache.Entries()
+}
+
+func (m *abstractMount) RestorePaths(paths map[string]string) {
+	for pathSegment, blobID := range paths {
+		m.cache.SetBlobID(cleanSegment(pathSegment), blobID)
+	}
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// childrenMap maps the names of the results of a query to their blob IDs.
+func childrenMap(results *payload.QueryResponse) map[string]string {
+	hitMap := make(map[string]string, len(results.Hits))
+	for _, hit := range results.Hits {
+		hitMap[hit.Metadata.Name] = hit.ID
+	}
+	return hitMap
+}
+
+// cleanSegment normalizes a path within a mount to the format used as path cache keys.
+func cleanSegment(pathSegment string) string {
+	pathSegment = filepath.Clean(pathSegment)
+	if pathSegment == "." || pathSegment == "/" {
+		return ""
+	}
+	return strings.TrimPrefix(pathSegment, "/")
+}
@@ -5,8 +5,8 @@ import (
 	"errors"
 	"path/filepath"
 
-	"github.com/menmos/menmos-go"
 	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
 	"github.com/menmos/menmos-mount/entry"
 	"github.com/rclone/rclone/fs"
 )
@@ -17,19 +17,25 @@ type blobMount struct {
 	BlobID string
 }
 
-func NewBlobMount(blobID string, client *menmos.Client, fs fs.Info) MountPoint {
+func NewBlobMount(blobID string, client *cluster.Client, fs fs.Info, options Options) MountPoint {
 	return &blobMount{
-		abstractMount: &abstractMount{client, fs, newPathCache()},
+		abstractMount: newAbstractMount(client, fs, options),
 		BlobID:        blobID,
 	}
 }
 
 func (m *blobMount) ListEntries(ctx context.Context, pathSegment string, fullpath string) (fs.DirEntries, error) {
+	rootQuery := payload.NewStructuredQuery(payload.NewExpression().AndParent(m.BlobID))
 	if pathSegment == "" || pathSegment == "." {
-		return m.getEntriesFromQuery(payload.NewStructuredQuery(payload.NewExpression().AndParent(m.BlobID)), fullpath)
+		return m.getEntriesFromQuery(ctx, rootQuery, m.BlobID, "", fullpath)
 	}
 
-	if err := m.ensurePathInCache(pathSegment); err != nil {
+	// Pre-populate the cache with the children of the mounted blob, this is where path resolution starts.
+	if _, err := m.getQueryChildrenMap(ctx, rootQuery, ""); err != nil {
+		return nil, err
+	}
+
+	if err := m.ensurePathInCache(ctx, pathSegment); err != nil {
 		return nil, err
 	}
 
@@ -38,12 +44,12 @@ func (m *blobMount) ListEntries(ctx context.Context, pathSegment string, fullpat
 		return nil, errors.New("cache walkback failed: unknown directory")
 	}
 
-	return m.getEntriesFromQuery(payload.NewStructuredQuery(payload.NewExpression().AndParent(targetDirBlobID)), fullpath)
+	return m.getEntriesFromQuery(ctx, payload.NewStructuredQuery(payload.NewExpression().AndParent(targetDirBlobID)), targetDirBlobID, pathSegment, fullpath)
 }
 
-func (m *blobMount) ResolveBlobDirectory(path string) (*entry.DirectoryBlobEntry, bool) {
+func (m *blobMount) ResolveBlobDirectory(ctx context.Context, path string) (*entry.DirectoryBlobEntry, bool) {
 	if path == "" {
-		meta, err := m.client.GetMetadata(m.BlobID)
+		meta, err := m.client.GetMetadata(ctx, m.BlobID)
 		if err != nil {
 			return nil, false
 		}
@@ -53,7 +59,7 @@ func (m *blobMount) ResolveBlobDirectory(path string) (*entry.DirectoryBlobEntry
 
 	parentDir := filepath.Dir(path)
 	base := filepath.Base(path)
-	entries, err := m.ListEntries(context.Background(), parentDir, parentDir)
+	entries, err := m.ListEntries(ctx, parentDir, parentDir)
 	if err != nil {
 		// TODO: Log
 		return nil, false
@@ -72,11 +78,11 @@ func (m *blobMount) ResolveBlobDirectory(path string) (*entry.DirectoryBlobEntry
 	return nil, false
 }
 
-func (m *blobMount) ResolveBlobFile(path string) (*entry.FileBlobEntry, bool) {
+func (m *blobMount) ResolveBlobFile(ctx context.Context, path string) (*entry.FileBlobEntry, bool) {
 	fs.Infof(nil, "Blob Mount resolving blob file: %s", path)
 	parentDir := filepath.Dir(path)
 	base := filepath.Base(path)
-	entries, err := m.ListEntries(context.Background(), parentDir, parentDir)
+	entries, err := m.ListEntries(ctx, parentDir, parentDir)
 	if err != nil {
 		// TODO: Log
 		return nil, false
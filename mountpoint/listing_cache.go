@@ -0,0 +1,184 @@
+package mountpoint
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/menmos/menmos-go/payload"
+)
+
+// Default listing cache parameters.
+const (
+	DefaultListingCacheTTL  = 5 * time.Second
+	DefaultListingCacheSize = 1024
+)
+
+// A ListingCache keeps recent query results around, so listing & resolving paths don't query the cluster every time.
+// Expired results are kept until evicted, to be served while the cluster is unreachable.
+// It is shared by all mounts of a tree and is safe for concurrent use. A nil cache caches nothing.
+type ListingCache struct {
+	mutex sync.Mutex
+
+	ttl     time.Duration
+	maxSize int
+
+	entries map[string]*list.Element
+	order   *list.List // Oldest entries first.
+}
+
+type listingCacheEntry struct {
+	key       string
+	response  *payload.QueryResponse
+	expiresAt time.Time
+}
+
+// NewListingCache returns a cache keeping at most `maxSize` query results for `ttl`.
+// It returns nil (no caching) if either parameter isn't positive.
+func NewListingCache(ttl time.Duration, maxSize int) *ListingCache {
+	if ttl <= 0 || maxSize <= 0 {
+		return nil
+	}
+
+	return &ListingCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// listingKey returns the cache key of a query.
+// Keys don't depend on paging, since cached responses always hold all the results of their query.
+func listingKey(query *payload.Query) (string, error) {
+	normalized := *query
+	key, err := json.Marshal(normalized.WithFrom(0).WithSize(0).WithSignURLs(false))
+	return string(key), err
+}
+
+func (c *ListingCache) get(key string) (*payload.QueryResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	cached := element.Value.(*listingCacheEntry)
+	if time.Now().After(cached.expiresAt) {
+		return nil, false
+	}
+
+	return cached.response, true
+}
+
+// getStale returns the last known result of a query, even if it expired.
+func (c *ListingCache) getStale(key string) (*payload.QueryResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	return element.Value.(*listingCacheEntry).response, true
+}
+
+func (c *ListingCache) set(key string, response *payload.QueryResponse) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+
+	for c.order.Len() >= c.maxSize {
+		c.removeElement(c.order.Front())
+	}
+
+	c.entries[key] = c.order.PushBack(&listingCacheEntry{key: key, response: response, expiresAt: time.Now().Add(c.ttl)})
+}
+
+// InvalidateParent drops the cached listing of the children of a directory blob.
+// An empty parent ID drops every cached listing.
+func (c *ListingCache) InvalidateParent(parentID string) {
+	if c == nil {
+		return
+	}
+
+	if parentID == "" {
+		c.Clear()
+		return
+	}
+
+	key, err := listingKey(payload.NewStructuredQuery(payload.NewExpression().AndParent(parentID)))
+	if err != nil {
+		c.Clear()
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+// Entries returns the last known result of every cached query, keyed by the serialized query.
+// Expired results are included.
+func (c *ListingCache) Entries() map[string]*payload.QueryResponse {
+	entries := make(map[string]*payload.QueryResponse)
+	if c == nil {
+		return entries
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, element := range c.entries {
+		entries[key] = element.Value.(*listingCacheEntry).response
+	}
+	return entries
+}
+
+// Restore seeds the cache with results previously returned by Entries.
+// Restored results are served for one TTL, after which they are fetched again from the cluster.
+func (c *ListingCache) Restore(entries map[string]*payload.QueryResponse) {
+	for key, response := range entries {
+		c.set(key, response)
+	}
+}
+
+// Clear drops every cached listing.
+func (c *ListingCache) Clear() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+}
+
+func (c *ListingCache) removeElement(element *list.Element) {
+	delete(c.entries, element.Value.(*listingCacheEntry).key)
+	c.order.Remove(element)
+}
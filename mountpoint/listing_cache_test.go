@@ -0,0 +1,124 @@
+package mountpoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
+)
+
+func parentKey(t *testing.T, parentID string) string {
+	t.Helper()
+
+	key, err := listingKey(payload.NewStructuredQuery(payload.NewExpression().AndParent(parentID)))
+	if err != nil {
+		t.Fatalf("failed to compute listing key: %v", err)
+	}
+	return key
+}
+
+func TestListingCacheExpiresResults(t *testing.T) {
+	cache := NewListingCache(20*time.Millisecond, 10)
+	response := &payload.QueryResponse{Total: 1}
+
+	cache.set("query", response)
+	if cached, ok := cache.get("query"); !ok || cached != response {
+		t.Errorf("expected the result to be cached, got %v (%v)", cached, ok)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := cache.get("query"); ok {
+		t.Error("expected the result to expire")
+	}
+}
+
+func TestListingCacheEvictsOldestResults(t *testing.T) {
+	cache := NewListingCache(time.Minute, 2)
+	cache.set("a", &payload.QueryResponse{})
+	cache.set("b", &payload.QueryResponse{})
+	cache.set("a", &payload.QueryResponse{})
+	cache.set("c", &payload.QueryResponse{})
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("expected 'b' to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("expected '%s' to be cached", key)
+		}
+	}
+}
+
+func TestListingCacheInvalidateParent(t *testing.T) {
+	cache := NewListingCache(time.Minute, 10)
+	cache.set(parentKey(t, "1"), &payload.QueryResponse{})
+	cache.set(parentKey(t, "2"), &payload.QueryResponse{})
+
+	cache.InvalidateParent("1")
+	if _, ok := cache.get(parentKey(t, "1")); ok {
+		t.Error("expected the listing of '1' to be dropped")
+	}
+	if _, ok := cache.get(parentKey(t, "2")); !ok {
+		t.Error("expected the listing of '2' to be kept")
+	}
+
+	cache.InvalidateParent("")
+	if _, ok := cache.get(parentKey(t, "2")); ok {
+		t.Error("expected every listing to be dropped")
+	}
+}
+
+func TestListingKeyIgnoresPaging(t *testing.T) {
+	query := payload.NewStructuredQuery(payload.NewExpression().AndParent("1"))
+	paged := payload.NewStructuredQuery(payload.NewExpression().AndParent("1")).WithFrom(50).WithSize(50)
+
+	key, err := listingKey(query)
+	if err != nil {
+		t.Fatalf("failed to compute listing key: %v", err)
+	}
+	if pagedKey, _ := listingKey(paged); pagedKey != key {
+		t.Error("expected pages of a query to share a key")
+	}
+}
+
+func TestNilListingCache(t *testing.T) {
+	cache := NewListingCache(0, 10)
+	cache.set("query", &payload.QueryResponse{})
+	if _, ok := cache.get("query"); ok {
+		t.Error("expected a disabled cache to cache nothing")
+	}
+}
+
+// unreachableClient returns a client of a cluster which went away after logging in.
+func unreachableClient(t *testing.T) *cluster.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"token":"token"}`))
+	}))
+	defer server.Close()
+
+	return newTestClient(t, server.URL)
+}
+
+func TestListingCacheServesExpiredResultsWhileTheClusterIsUnreachable(t *testing.T) {
+	client := unreachableClient(t)
+	options := Options{Listings: NewListingCache(time.Millisecond, 10)}
+	query := payload.NewStructuredQuery(payload.NewExpression().AndParent("1"))
+
+	if _, err := getFullQueryResults(context.Background(), query, client, options); !cluster.IsUnavailable(err) {
+		t.Errorf("expected the cluster to be unavailable without a cached result, got %v", err)
+	}
+
+	response := &payload.QueryResponse{Total: 1}
+	options.Listings.set(parentKey(t, "1"), response)
+	time.Sleep(5 * time.Millisecond)
+
+	if stale, err := getFullQueryResults(context.Background(), query, client, options); err != nil || stale != response {
+		t.Errorf("expected the expired result to be served, got %v (%v)", stale, err)
+	}
+}
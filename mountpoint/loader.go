@@ -2,41 +2,48 @@ package mountpoint
 
 import (
 	"errors"
+	"time"
 
-	"github.com/menmos/menmos-go"
 	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
 	"github.com/mitchellh/mapstructure"
 	"github.com/rclone/rclone/fs"
 )
 
 type MountBuilder interface {
-	IntoMount(client *menmos.Client, fs fs.Info) (MountPoint, error)
+	IntoMount(client *cluster.Client, fs fs.Info, options Options) (MountPoint, error)
 }
 
 type rawQueryMount struct {
 	Expression      map[string]interface{} `json:"expression"`
 	GroupByTags     bool                   `json:"group_by_tags,omitempty"`
 	GroupByMetaKeys []string               `json:"group_by_meta_keys,omitempty"`
+	PollInterval    time.Duration          `json:"poll_interval,omitempty"`
 }
 
-func (r rawQueryMount) IntoMount(client *menmos.Client, fs fs.Info) (MountPoint, error) {
+func (r rawQueryMount) IntoMount(client *cluster.Client, fs fs.Info, options Options) (MountPoint, error) {
 	parsedExpression, err := payload.ParseExpression(r.Expression)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewQueryMount(parsedExpression, r.GroupByTags, r.GroupByMetaKeys, client, fs), nil
+	options.PollInterval = r.PollInterval
+	return NewQueryMount(parsedExpression, r.GroupByTags, r.GroupByMetaKeys, client, fs, options), nil
 }
 
 type rawBlobMount struct {
-	BlobID string `json:"blob_id"`
+	BlobID       string        `json:"blob_id"`
+	PollInterval time.Duration `json:"poll_interval,omitempty"`
 }
 
-func (r rawBlobMount) IntoMount(client *menmos.Client, fs fs.Info) (MountPoint, error) {
-	return NewBlobMount(r.BlobID, client, fs), nil
+func (r rawBlobMount) IntoMount(client *cluster.Client, fs fs.Info, options Options) (MountPoint, error) {
+	options.PollInterval = r.PollInterval
+	return NewBlobMount(r.BlobID, client, fs, options), nil
 }
 
-func Load(rawDict map[string]interface{}, client *menmos.Client, fs fs.Info) (MountPoint, error) {
+// Load builds a mount tree from its JSON specification. All mounts of the tree share the same options,
+// except for the poll interval which can be set on each query & blob mount (e.g. "poll_interval": "30s").
+func Load(rawDict map[string]interface{}, client *cluster.Client, fs fs.Info, options Options) (MountPoint, error) {
 	var mountData MountBuilder
 	if _, ok := rawDict["expression"]; ok {
 		mountData = rawQueryMount{}
@@ -47,7 +54,7 @@ func Load(rawDict map[string]interface{}, client *menmos.Client, fs fs.Info) (Mo
 		subMounts := make(map[string]MountPoint)
 		for mountName, data := range rawDict {
 			if dataMap, ok := data.(map[string]interface{}); ok {
-				subMount, err := Load(dataMap, client, fs)
+				subMount, err := Load(dataMap, client, fs, options)
 				if err != nil {
 					return nil, err
 				}
@@ -59,7 +66,11 @@ func Load(rawDict map[string]interface{}, client *menmos.Client, fs fs.Info) (Mo
 		return NewVirtualMount(subMounts), nil
 	}
 
-	decoderConfig := mapstructure.DecoderConfig{TagName: "json", Result: &mountData}
+	decoderConfig := mapstructure.DecoderConfig{
+		TagName:    "json",
+		Result:     &mountData,
+		DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+	}
 	decoder, err := mapstructure.NewDecoder(&decoderConfig)
 	if err != nil {
 		return nil, err
@@ -69,5 +80,5 @@ func Load(rawDict map[string]interface{}, client *menmos.Client, fs fs.Info) (Mo
 		return nil, err
 	}
 
-	return mountData.IntoMount(client, fs)
+	return mountData.IntoMount(client, fs, options)
 }
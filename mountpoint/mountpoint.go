@@ -2,6 +2,7 @@ package mountpoint
 
 import (
 	"context"
+	"time"
 
 	"github.com/menmos/menmos-mount/entry"
 	"github.com/rclone/rclone/fs"
@@ -9,6 +10,28 @@ import (
 
 type MountPoint interface {
 	ListEntries(ctx context.Context, path string, fullpath string) (fs.DirEntries, error)
-	ResolveBlobDirectory(path string) (*entry.DirectoryBlobEntry, bool)
-	ResolveBlobFile(path string) (*entry.FileBlobEntry, bool)
+	ResolveBlobDirectory(ctx context.Context, path string) (*entry.DirectoryBlobEntry, bool)
+	ResolveBlobFile(ctx context.Context, path string) (*entry.FileBlobEntry, bool)
+
+	// Invalidate drops any cached information about a single path.
+	Invalidate(path string)
+
+	// InvalidateTree drops any cached information about a path and everything below it.
+	InvalidateTree(path string)
+
+	// PathCacheStats returns the path cache counters of the mount and all its sub-mounts.
+	PathCacheStats() PathCacheStats
+
+	// CachedPaths returns all cached path to blob ID mappings of the mount and its sub-mounts.
+	CachedPaths() map[string]string
+
+	// RestorePaths seeds the path caches with mappings previously returned by CachedPaths.
+	RestorePaths(paths map[string]string)
+
+	// Poll looks for changes made by other clients in the directories listed so far, in every mount whose poll
+	// interval (or `defaultInterval` if it has none) elapsed since it was last polled.
+	Poll(ctx context.Context, defaultInterval time.Duration, notify ChangeNotifyFunc)
+
+	// PollInterval returns the smallest poll interval configured in the mount tree, or 0 if there is none.
+	PollInterval() time.Duration
 }
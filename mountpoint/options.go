@@ -0,0 +1,21 @@
+package mountpoint
+
+import "time"
+
+// Options are shared by all mounts of a tree.
+type Options struct {
+	// Listings caches query results. A nil cache disables listing caching.
+	Listings *ListingCache
+
+	// PathCacheSize is the maximum number of paths cached by each mount, and of directories it polls for changes.
+	PathCacheSize int
+
+	// QueryPageSize is the number of results fetched by each query request.
+	QueryPageSize int
+	// QueryConcurrency is the maximum number of pages of a query fetched concurrently.
+	QueryConcurrency int
+
+	// PollInterval is how often the directories listed in a mount are polled for changes.
+	// Zero uses the poll interval of the filesystem, a negative value disables polling.
+	PollInterval time.Duration
+}
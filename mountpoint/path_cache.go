@@ -1,31 +1,80 @@
 package mountpoint
 
 import (
+	"container/list"
+	"strings"
 	"sync"
-
-	"github.com/menmos/menmos-go/payload"
+	"sync/atomic"
 )
 
+// DefaultPathCacheSize is the default maximum number of paths cached by each mount.
+const DefaultPathCacheSize = 10000
+
+// PathCacheStats are the counters of a path cache.
+type PathCacheStats struct {
+	Size      int    `json:"size"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// Add returns the sum of two sets of counters.
+func (s PathCacheStats) Add(other PathCacheStats) PathCacheStats {
+	return PathCacheStats{
+		Size:      s.Size + other.Size,
+		Hits:      s.Hits + other.Hits,
+		Misses:    s.Misses + other.Misses,
+		Evictions: s.Evictions + other.Evictions,
+	}
+}
+
+// pathCache maps paths to blob IDs, keeping at most maxSize paths.
+// Eviction approximates LRU with a second-chance queue: lookups only flag entries as referenced,
+// so they can share a read lock.
 type pathCache struct {
-	mutex sync.Mutex
-	data  map[string]string
+	// Counters are accessed atomically and kept first for alignment.
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	mutex    sync.RWMutex
+	data     map[string]*list.Element
+	children map[string]map[string]struct{} // Paths which are cached or have cached descendants, by parent.
+	order    *list.List                     // Eviction candidates first.
+	maxSize  int
+}
+
+type pathCacheEntry struct {
+	path       string
+	blobID     string
+	referenced int32
 }
 
-func newPathCache() *pathCache {
+func newPathCache(maxSize int) *pathCache {
+	if maxSize <= 0 {
+		maxSize = DefaultPathCacheSize
+	}
+
 	return &pathCache{
-		mutex: sync.Mutex{},
-		data:  make(map[string]string),
+		data:     make(map[string]*list.Element),
+		children: make(map[string]map[string]struct{}),
+		order:    list.New(),
+		maxSize:  maxSize,
 	}
 }
 
 func (c *pathCache) GetBlobID(pathSegment string) (string, bool) {
-	c.mutex.Lock()
-	defer c.mutex.Unlock()
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 
-	if blobID, ok := c.data[pathSegment]; ok {
-		return blobID, ok
+	if element, ok := c.data[pathSegment]; ok {
+		cached := element.Value.(*pathCacheEntry)
+		atomic.StoreInt32(&cached.referenced, 1)
+		atomic.AddUint64(&c.hits, 1)
+		return cached.blobID, true
 	}
 
+	atomic.AddUint64(&c.misses, 1)
 	return "", false
 }
 
@@ -33,7 +82,177 @@ func (c *pathCache) SetBlobID(pathSegment string, blobID string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	c.data[pathSegment] = blobID
+	c.set(pathSegment, blobID)
+}
+
+// SetChildren replaces the cached children of a directory with a fresh listing (mapping names to blob IDs).
+// Children that disappeared or now point to another blob are invalidated along with everything below them.
+func (c *pathCache) SetChildren(dirSegment string, children map[string]string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	prefix := ""
+	if dirSegment != "" {
+		prefix = dirSegment + "/"
+	}
+
+	for childPath := range c.children[dirSegment] {
+		childID, ok := children[strings.TrimPrefix(childPath, prefix)]
+		if element, cached := c.data[childPath]; !ok || (cached && childID != element.Value.(*pathCacheEntry).blobID) {
+			c.invalidateTree(childPath)
+		}
+	}
+
+	for name, blobID := range children {
+		c.set(prefix+name, blobID)
+	}
+}
+
+// Invalidate removes a single path from the cache.
+func (c *pathCache) Invalidate(pathSegment string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.data[pathSegment]; ok {
+		c.remove(element)
+	}
+}
+
+// InvalidateTree removes a path and every path below it from the cache.
+func (c *pathCache) InvalidateTree(pathSegment string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.invalidateTree(pathSegment)
+}
+
+// Entries returns a copy of all the cached mappings.
+func (c *pathCache) Entries() map[string]string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	entries := make(map[string]string, len(c.data))
+	for cachedPath, element := range c.data {
+		entries[cachedPath] = element.Value.(*pathCacheEntry).blobID
+	}
+	return entries
+}
+
+// Stats returns the current counters of the cache.
+func (c *pathCache) Stats() PathCacheStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return PathCacheStats{
+		Size:      len(c.data),
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+}
+
+func (c *pathCache) set(pathSegment string, blobID string) {
+	if element, ok := c.data[pathSegment]; ok {
+		cached := element.Value.(*pathCacheEntry)
+		cached.blobID = blobID
+		atomic.StoreInt32(&cached.referenced, 1)
+		return
+	}
+
+	for c.order.Len() >= c.maxSize {
+		c.evict()
+	}
+
+	c.data[pathSegment] = c.order.PushBack(&pathCacheEntry{path: pathSegment, blobID: blobID})
+	c.link(pathSegment)
 }
 
-func (c *pathCache) SetQuery(pathSegment string, query *payload.Query) {}
+// evict removes the oldest entry that wasn't looked up since it was last considered for eviction.
+func (c *pathCache) evict() {
+	for {
+		element := c.order.Front()
+		cached := element.Value.(*pathCacheEntry)
+		if atomic.SwapInt32(&cached.referenced, 0) == 1 {
+			c.order.MoveToBack(element)
+			continue
+		}
+
+		c.remove(element)
+		atomic.AddUint64(&c.evictions, 1)
+		return
+	}
+}
+
+// invalidateTree removes a path and the paths below it, walking down the child index so the cost is proportional
+// to the size of the subtree.
+func (c *pathCache) invalidateTree(pathSegment string) {
+	if pathSegment == "" {
+		c.data = make(map[string]*list.Element)
+		c.children = make(map[string]map[string]struct{})
+		c.order.Init()
+		return
+	}
+
+	for childPath := range c.children[pathSegment] {
+		c.invalidateTree(childPath)
+	}
+
+	if element, ok := c.data[pathSegment]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *pathCache) remove(element *list.Element) {
+	cachedPath := element.Value.(*pathCacheEntry).path
+	delete(c.data, cachedPath)
+	c.order.Remove(element)
+	c.unlink(cachedPath)
+}
+
+// link adds a path to the child index, along with its ancestors.
+func (c *pathCache) link(pathSegment string) {
+	parent, ok := parentSegment(pathSegment)
+	if !ok {
+		return
+	}
+
+	siblings, ok := c.children[parent]
+	if !ok {
+		siblings = make(map[string]struct{})
+		c.children[parent] = siblings
+		c.link(parent)
+	}
+	siblings[pathSegment] = struct{}{}
+}
+
+// unlink removes a path from the child index once it is neither cached nor has cached descendants,
+// along with the ancestors left without any.
+func (c *pathCache) unlink(pathSegment string) {
+	if _, cached := c.data[pathSegment]; cached || len(c.children[pathSegment]) > 0 {
+		return
+	}
+
+	parent, ok := parentSegment(pathSegment)
+	if !ok {
+		return
+	}
+
+	siblings := c.children[parent]
+	delete(siblings, pathSegment)
+	if len(siblings) == 0 {
+		delete(c.children, parent)
+		c.unlink(parent)
+	}
+}
+
+// parentSegment returns the path of the directory containing a path, or false for the root.
+func parentSegment(pathSegment string) (string, bool) {
+	if pathSegment == "" {
+		return "", false
+	}
+
+	if i := strings.LastIndex(pathSegment, "/"); i >= 0 {
+		return pathSegment[:i], true
+	}
+	return "", true
+}
@@ -0,0 +1,118 @@
+package mountpoint
+
+import (
+	"testing"
+)
+
+func assertCached(t *testing.T, cache *pathCache, pathSegment string, expectedID string) {
+	t.Helper()
+
+	blobID, ok := cache.GetBlobID(pathSegment)
+	if !ok {
+		t.Errorf("expected '%s' to be cached", pathSegment)
+		return
+	}
+	if blobID != expectedID {
+		t.Errorf("expected '%s' to map to '%s', got '%s'", pathSegment, expectedID, blobID)
+	}
+}
+
+func assertNotCached(t *testing.T, cache *pathCache, pathSegment string) {
+	t.Helper()
+
+	if blobID, ok := cache.GetBlobID(pathSegment); ok {
+		t.Errorf("expected '%s' not to be cached, got '%s'", pathSegment, blobID)
+	}
+}
+
+func TestPathCacheEvictsUnreferencedEntriesFirst(t *testing.T) {
+	cache := newPathCache(3)
+	cache.SetBlobID("a", "1")
+	cache.SetBlobID("b", "2")
+	cache.SetBlobID("c", "3")
+
+	// "a" gets a second chance, so "b" is the oldest unreferenced entry.
+	cache.GetBlobID("a")
+	cache.SetBlobID("d", "4")
+
+	assertNotCached(t, cache, "b")
+	assertCached(t, cache, "a", "1")
+	assertCached(t, cache, "c", "3")
+	assertCached(t, cache, "d", "4")
+
+	if stats := cache.Stats(); stats.Size != 3 || stats.Evictions != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestPathCacheSetMarksExistingEntriesReferenced(t *testing.T) {
+	cache := newPathCache(2)
+	cache.SetBlobID("a", "1")
+	cache.SetBlobID("b", "2")
+
+	cache.SetBlobID("a", "3")
+	cache.SetBlobID("c", "4")
+
+	assertNotCached(t, cache, "b")
+	assertCached(t, cache, "a", "3")
+}
+
+func TestPathCacheSetChildren(t *testing.T) {
+	cache := newPathCache(0)
+	cache.SetBlobID("dir", "1")
+	cache.SetBlobID("dir/kept", "2")
+	cache.SetBlobID("dir/kept/child", "3")
+	cache.SetBlobID("dir/moved", "4")
+	cache.SetBlobID("dir/moved/child", "5")
+	cache.SetBlobID("dir/deleted", "6")
+	cache.SetBlobID("dir/deleted/child", "7")
+	cache.SetBlobID("dirty", "8")
+
+	cache.SetChildren("dir", map[string]string{"kept": "2", "moved": "9", "added": "10"})
+
+	assertCached(t, cache, "dir", "1")
+	assertCached(t, cache, "dir/kept", "2")
+	assertCached(t, cache, "dir/kept/child", "3")
+	assertCached(t, cache, "dir/moved", "9")
+	assertNotCached(t, cache, "dir/moved/child")
+	assertNotCached(t, cache, "dir/deleted")
+	assertNotCached(t, cache, "dir/deleted/child")
+	assertCached(t, cache, "dir/added", "10")
+	assertCached(t, cache, "dirty", "8")
+}
+
+func TestPathCacheSetChildrenOfRoot(t *testing.T) {
+	cache := newPathCache(0)
+	cache.SetBlobID("a", "1")
+	cache.SetBlobID("a/b", "2")
+	cache.SetBlobID("c", "3")
+
+	cache.SetChildren("", map[string]string{"c": "3"})
+
+	assertNotCached(t, cache, "a")
+	assertNotCached(t, cache, "a/b")
+	assertCached(t, cache, "c", "3")
+}
+
+func TestPathCacheInvalidateTree(t *testing.T) {
+	cache := newPathCache(0)
+	cache.SetBlobID("a", "1")
+	cache.SetBlobID("a/b", "2")
+	cache.SetBlobID("a/b/c", "3")
+	cache.SetBlobID("ab", "4")
+
+	// Paths below a directory which isn't cached itself are still found.
+	cache.Invalidate("a/b")
+	cache.InvalidateTree("a")
+
+	assertNotCached(t, cache, "a")
+	assertNotCached(t, cache, "a/b/c")
+	assertCached(t, cache, "ab", "4")
+
+	if stats := cache.Stats(); stats.Size != 1 {
+		t.Errorf("expected a single cached path, got %d", stats.Size)
+	}
+	if len(cache.children) != 1 || len(cache.children[""]) != 1 {
+		t.Errorf("expected only 'ab' to be indexed, got %v", cache.children)
+	}
+}
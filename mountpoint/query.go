@@ -6,9 +6,11 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/menmos/menmos-go"
 	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
 	"github.com/menmos/menmos-mount/entry"
 	"github.com/rclone/rclone/fs"
 )
@@ -20,21 +22,26 @@ type queryMount struct {
 
 	GroupByTags     bool
 	GroupByMetaKeys []string
+
+	// The sub-mounts of grouped queries, keyed by group ("Tags" or a metadata key) then by value. They are kept
+	// across listings so their caches and watched directories survive.
+	groupsMutex sync.Mutex
+	groups      map[string]map[string]MountPoint
 }
 
-func NewQueryMount(expression payload.Expression, groupByTags bool, groupByMetaKeys []string, client *menmos.Client, fs fs.Info) *queryMount {
+func NewQueryMount(expression payload.Expression, groupByTags bool, groupByMetaKeys []string, client *cluster.Client, fs fs.Info, options Options) *queryMount {
 	return &queryMount{
-		abstractMount: &abstractMount{
-			client,
-			fs,
-			newPathCache(),
-		},
+		abstractMount:   newAbstractMount(client, fs, options),
 		Expression:      expression,
 		GroupByTags:     groupByTags,
 		GroupByMetaKeys: groupByMetaKeys,
 	}
 }
 
+func (m *queryMount) grouped() bool {
+	return m.GroupByTags || len(m.GroupByMetaKeys) > 0
+}
+
 func (m *queryMount) groupByKeysContains(key string) bool {
 	for _, v := range m.GroupByMetaKeys {
 		if v == key {
@@ -57,9 +64,27 @@ func (m *queryMount) listNestedEntries(ctx context.Context, pathSegment string,
 
 	splitted := strings.SplitN(pathSegment, "/", 2)
 	head := splitted[0]
+	if head != "Tags" && !m.groupByKeysContains(head) {
+		return nil, fs.ErrorDirNotFound
+	}
+
+	if _, err := m.refreshGroups(ctx); err != nil {
+		return nil, err
+	}
+
+	tail := ""
+	if len(splitted) == 2 {
+		tail = splitted[1]
+	}
 
+	return m.group(head).ListEntries(ctx, tail, fullpath)
+}
+
+// refreshGroups fetches the values of every group, creating the sub-mounts of new values and dropping those of values
+// which disappeared. It returns the groups whose values changed since they were last refreshed.
+func (m *queryMount) refreshGroups(ctx context.Context) ([]string, error) {
 	rootQuery := payload.NewStructuredQuery(m.Expression).WithSize(0).WithFacets(true) // We're grouping, we don't need any results.
-	results, err := m.client.Query(rootQuery)
+	results, err := m.client.Query(ctx, rootQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -69,53 +94,97 @@ func (m *queryMount) listNestedEntries(ctx context.Context, pathSegment string,
 		return nil, errors.New("no facets returned")
 	}
 
-	if head == "Tags" {
-		tagMountMap := make(map[string]MountPoint)
-		for tag := range facets.Tags {
-			tagMountMap[tag] = NewQueryMount(m.Expression.AndTag(tag), false, []string{}, m.client, m.fs)
-		}
-		mount := &virtualMount{mounts: tagMountMap}
+	m.groupsMutex.Lock()
+	defer m.groupsMutex.Unlock()
 
-		tail := ""
-		if len(splitted) == 2 {
-			tail = splitted[1]
+	if m.groups == nil {
+		m.groups = make(map[string]map[string]MountPoint)
+	}
+
+	var changed []string
+	refresh := func(group string, values map[string]uint64, expression func(value string) payload.Expression) {
+		oldMounts, known := m.groups[group]
+		mounts := make(map[string]MountPoint, len(values))
+		for value := range values {
+			if mount, ok := oldMounts[value]; ok {
+				mounts[value] = mount
+			} else {
+				mounts[value] = NewQueryMount(expression(value), false, []string{}, m.client, m.fs, m.options)
+			}
 		}
 
-		return mount.ListEntries(ctx, tail, fullpath)
-	} else if m.groupByKeysContains(head) { // Head is a k/v key
-		kvMountMap := make(map[string]MountPoint)
-		for value := range facets.Meta[head] {
-			kvMountMap[value] = NewQueryMount(m.Expression.AndKeyValue(head, value), false, []string{}, m.client, m.fs)
+		if known && !sameValues(oldMounts, mounts) {
+			changed = append(changed, group)
 		}
-		mount := &virtualMount{mounts: kvMountMap}
+		m.groups[group] = mounts
+	}
+
+	refresh("Tags", facets.Tags, func(tag string) payload.Expression {
+		return m.Expression.AndTag(tag)
+	})
+	for _, metaKey := range m.GroupByMetaKeys {
+		metaKey := metaKey
+		refresh(metaKey, facets.Meta[metaKey], func(value string) payload.Expression {
+			return m.Expression.AndKeyValue(metaKey, value)
+		})
+	}
+
+	return changed, nil
+}
 
-		tail := ""
-		if len(splitted) == 2 {
-			tail = splitted[1]
+// sameValues returns whether two groups of sub-mounts have the same values.
+func sameValues(a map[string]MountPoint, b map[string]MountPoint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for value := range a {
+		if _, ok := b[value]; !ok {
+			return false
 		}
+	}
+	return true
+}
+
+// group returns a virtual mount of the sub-mounts of a group, as of the last time groups were refreshed.
+func (m *queryMount) group(name string) *virtualMount {
+	m.groupsMutex.Lock()
+	defer m.groupsMutex.Unlock()
+
+	mounts := make(map[string]MountPoint, len(m.groups[name]))
+	for value, mount := range m.groups[name] {
+		mounts[value] = mount
+	}
+	return &virtualMount{mounts: mounts}
+}
 
-		return mount.ListEntries(ctx, tail, fullpath)
+// groupsMount returns a virtual mount of every group.
+func (m *queryMount) groupsMount() *virtualMount {
+	m.groupsMutex.Lock()
+	names := make([]string, 0, len(m.groups))
+	for name := range m.groups {
+		names = append(names, name)
 	}
+	m.groupsMutex.Unlock()
 
-	return nil, fs.ErrorDirNotFound
+	mounts := make(map[string]MountPoint, len(names))
+	for _, name := range names {
+		mounts[name] = m.group(name)
+	}
+	return &virtualMount{mounts: mounts}
 }
 
-func (m *queryMount) listFlatEntries(pathSegment string, fullpath string) (fs.DirEntries, error) {
+func (m *queryMount) listFlatEntries(ctx context.Context, pathSegment string, fullpath string) (fs.DirEntries, error) {
 	rootQuery := payload.NewStructuredQuery(m.Expression)
 	if pathSegment == "" || pathSegment == "." {
-		return m.getEntriesFromQuery(rootQuery, fullpath)
+		return m.getEntriesFromQuery(ctx, rootQuery, "", "", fullpath)
 	}
 
 	// Pre-populate the cache with virtual files & directories.
-	childrenMap, err := m.getQueryChildrenMap(rootQuery)
-	if err != nil {
+	if _, err := m.getQueryChildrenMap(ctx, rootQuery, ""); err != nil {
 		return nil, err
 	}
-	for name, blobID := range childrenMap {
-		m.cache.SetBlobID(name, blobID)
-	}
 
-	if err := m.ensurePathInCache(pathSegment); err != nil {
+	if err := m.ensurePathInCache(ctx, pathSegment); err != nil {
 		return nil, err
 	}
 
@@ -124,25 +193,23 @@ func (m *queryMount) listFlatEntries(pathSegment string, fullpath string) (fs.Di
 		return nil, errors.New("cache walkback failed: unknown directory")
 	}
 
-	return m.getEntriesFromQuery(payload.NewStructuredQuery(payload.NewExpression().AndParent(targetDirBlobID)), fullpath)
+	return m.getEntriesFromQuery(ctx, payload.NewStructuredQuery(payload.NewExpression().AndParent(targetDirBlobID)), targetDirBlobID, pathSegment, fullpath)
 }
 
 func (m *queryMount) ListEntries(ctx context.Context, pathSegment string, fullpath string) (fs.DirEntries, error) {
 	fs.Infof(nil, "listing query entries for '%s'", pathSegment)
 
-	shouldGroup := m.GroupByTags || len(m.GroupByMetaKeys) > 0
-
-	if shouldGroup {
+	if m.grouped() {
 		return m.listNestedEntries(ctx, pathSegment, fullpath)
 	}
 
-	return m.listFlatEntries(pathSegment, fullpath)
+	return m.listFlatEntries(ctx, pathSegment, fullpath)
 }
 
-func (m *queryMount) ResolveBlobDirectory(path string) (*entry.DirectoryBlobEntry, bool) {
+func (m *queryMount) ResolveBlobDirectory(ctx context.Context, path string) (*entry.DirectoryBlobEntry, bool) {
 	parentDir := filepath.Dir(path)
 	base := filepath.Base(path)
-	entries, err := m.ListEntries(context.Background(), parentDir, parentDir)
+	entries, err := m.ListEntries(ctx, parentDir, parentDir)
 	if err != nil {
 		// TODO: Log
 		return nil, false
@@ -161,10 +228,10 @@ func (m *queryMount) ResolveBlobDirectory(path string) (*entry.DirectoryBlobEntr
 	return nil, false
 }
 
-func (m *queryMount) ResolveBlobFile(path string) (*entry.FileBlobEntry, bool) {
+func (m *queryMount) ResolveBlobFile(ctx context.Context, path string) (*entry.FileBlobEntry, bool) {
 	parentDir := filepath.Dir(path)
 	base := filepath.Base(path)
-	entries, err := m.ListEntries(context.Background(), parentDir, parentDir)
+	entries, err := m.ListEntries(ctx, parentDir, parentDir)
 	if err != nil {
 		// TODO: Log
 		return nil, false
@@ -182,3 +249,52 @@ func (m *queryMount) ResolveBlobFile(path string) (*entry.FileBlobEntry, bool) {
 
 	return nil, false
 }
+
+func (m *queryMount) Invalidate(pathSegment string) {
+	if m.grouped() {
+		m.groupsMount().Invalidate(cleanSegment(pathSegment))
+		return
+	}
+	m.abstractMount.Invalidate(pathSegment)
+}
+
+func (m *queryMount) InvalidateTree(pathSegment string) {
+	if m.grouped() {
+		m.groupsMount().InvalidateTree(cleanSegment(pathSegment))
+		return
+	}
+	m.abstractMount.InvalidateTree(pathSegment)
+}
+
+func (m *queryMount) PathCacheStats() PathCacheStats {
+	if m.grouped() {
+		return m.groupsMount().PathCacheStats()
+	}
+	return m.abstractMount.PathCacheStats()
+}
+
+// Poll polls the directories listed in the sub-mounts of grouped queries, after checking whether the values of the
+// groups changed.
+func (m *queryMount) Poll(ctx context.Context, defaultInterval time.Duration, notify ChangeNotifyFunc) {
+	if !m.grouped() {
+		m.abstractMount.Poll(ctx, defaultInterval, notify)
+		return
+	}
+
+	m.groupsMutex.Lock()
+	listed := m.groups != nil
+	m.groupsMutex.Unlock()
+
+	// Groups are only polled once they were listed, like any other directory.
+	if listed && m.watcher.due(time.Now(), defaultInterval) {
+		changed, err := m.refreshGroups(ctx)
+		if err != nil {
+			fs.Errorf(nil, "failed to poll groups for changes: %v", err)
+		}
+		for _, group := range changed {
+			notify(group, fs.EntryDirectory)
+		}
+	}
+
+	m.groupsMount().Poll(ctx, defaultInterval, notify)
+}
@@ -1,28 +1,141 @@
 package mountpoint
 
 import (
-	"github.com/menmos/menmos-go"
+	"context"
+
 	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
+	"golang.org/x/sync/errgroup"
+)
+
+// Default paging parameters of queries.
+const (
+	DefaultQueryPageSize    = 100
+	DefaultQueryConcurrency = 4
 )
 
-const queryBatchSize = 100
+// normalizeQuery returns a copy of a query set up to fetch its first page.
+func normalizeQuery(query *payload.Query, pageSize int) *payload.Query {
+	if pageSize <= 0 {
+		pageSize = DefaultQueryPageSize
+	}
+
+	normalized := *query
+	return normalized.WithFrom(0).WithSize(uint32(pageSize)).WithSignURLs(false) // No need to sign URLs.
+}
 
 // aggregates all query results (using paging) into a single query response object.
-func getFullQueryResults(query *payload.Query, client *menmos.Client) (*payload.QueryResponse, error) {
-	response, err := client.Query(query.WithFrom(0).WithSize(queryBatchSize).WithSignURLs(false)) // No need to sign URLs.
+// Responses are served from (and saved to) the listing cache when possible.
+// While the cluster is unreachable, the last known results are served even if they expired.
+func getFullQueryResults(ctx context.Context, query *payload.Query, client *cluster.Client, options Options) (*payload.QueryResponse, error) {
+	key, err := listingKey(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if response, ok := options.Listings.get(key); ok {
+		return response, nil
+	}
+
+	response, err := fetchQueryResults(ctx, normalizeQuery(query, options.QueryPageSize), client, options.QueryConcurrency)
+	if err != nil {
+		if cluster.IsUnavailable(err) {
+			if response, ok := options.Listings.getStale(key); ok {
+				return response, nil
+			}
+		}
+		return nil, err
+	}
+
+	options.Listings.set(key, response)
+
+	return response, nil
+}
+
+// refreshQueryResults fetches all results of a query from the cluster, bypassing and updating the listing cache.
+func refreshQueryResults(ctx context.Context, query *payload.Query, client *cluster.Client, options Options) (*payload.QueryResponse, error) {
+	key, err := listingKey(query)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := fetchQueryResults(ctx, normalizeQuery(query, options.QueryPageSize), client, options.QueryConcurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	options.Listings.set(key, response)
+
+	return response, nil
+}
+
+// fetchQueryResults pages through the results of a normalized query.
+// The first page tells us how many results there are, the remaining pages are then fetched by up to `concurrency`
+// concurrent requests.
+func fetchQueryResults(ctx context.Context, query *payload.Query, client *cluster.Client, concurrency int) (*payload.QueryResponse, error) {
+	if concurrency <= 0 {
+		concurrency = DefaultQueryConcurrency
+	}
+
+	response, err := client.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 
-	for response.Count < response.Total {
-		resp, err := client.Query(query.WithFrom(response.Count))
-		if err != nil {
-			return nil, err
+	// The cluster may return smaller pages than requested, the first page tells us the actual page size.
+	pageSize := response.Count
+	if pageSize == 0 || response.Count >= response.Total {
+		return response, nil
+	}
+
+	var offsets []uint32
+	for offset := response.Count; offset < response.Total; offset += pageSize {
+		offsets = append(offsets, offset)
+	}
+	pages := make([][]payload.Hit, len(offsets))
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	pageIndexes := make(chan int)
+
+	group.Go(func() error {
+		defer close(pageIndexes)
+		for i := range offsets {
+			select {
+			case pageIndexes <- i:
+			case <-groupCtx.Done():
+				return groupCtx.Err()
+			}
 		}
+		return nil
+	})
+
+	for i := 0; i < concurrency && i < len(offsets); i++ {
+		group.Go(func() error {
+			for pageIndex := range pageIndexes {
+				if err := groupCtx.Err(); err != nil {
+					return err
+				}
+
+				pageQuery := *query
+				resp, err := client.Query(groupCtx, pageQuery.WithFrom(offsets[pageIndex]))
+				if err != nil {
+					return err
+				}
+				pages[pageIndex] = resp.Hits
+			}
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
 
-		response.Count += resp.Count
-		response.Hits = append(response.Hits, resp.Hits...)
+	// Pages are stored by index, so hits keep the order of the cluster.
+	for _, hits := range pages {
+		response.Hits = append(response.Hits, hits...)
 	}
+	response.Count = uint32(len(response.Hits))
 
 	return response, nil
 }
@@ -0,0 +1,113 @@
+package mountpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/menmos/menmos-go"
+	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
+)
+
+func newTestClient(t *testing.T, url string) *cluster.Client {
+	t.Helper()
+
+	client, err := menmos.New(url, "user", "password")
+	if err != nil {
+		t.Fatalf("failed to log in: %v", err)
+	}
+	return cluster.NewClient(client, cluster.Limits{}, cluster.RetryPolicy{MaxAttempts: 1})
+}
+
+// A fakeQueryCluster answers queries with pages of at most pageSize of its total hits.
+type fakeQueryCluster struct {
+	total    int
+	pageSize int
+
+	mutex       sync.Mutex
+	requests    int
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *fakeQueryCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/auth/login" {
+		json.NewEncoder(w).Encode(payload.LoginResponse{Token: "token"})
+		return
+	}
+
+	c.mutex.Lock()
+	c.requests++
+	if c.inFlight++; c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		c.inFlight--
+		c.mutex.Unlock()
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	var query payload.Query
+	json.NewDecoder(r.Body).Decode(&query)
+
+	response := payload.QueryResponse{Total: uint32(c.total)}
+	for i := int(query.From); i < c.total && i < int(query.From)+c.pageSize && i < int(query.From+query.Size); i++ {
+		response.Hits = append(response.Hits, payload.Hit{ID: fmt.Sprint(i)})
+	}
+	response.Count = uint32(len(response.Hits))
+	json.NewEncoder(w).Encode(response)
+}
+
+func TestFetchQueryResultsPagesConcurrently(t *testing.T) {
+	fake := &fakeQueryCluster{total: 25, pageSize: 4}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	query := normalizeQuery(payload.NewStructuredQuery(payload.NewExpression()), 10)
+	response, err := fetchQueryResults(context.Background(), query, newTestClient(t, server.URL), 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if response.Count != 25 || len(response.Hits) != 25 {
+		t.Fatalf("expected 25 hits, got %d (count: %d)", len(response.Hits), response.Count)
+	}
+	for i, hit := range response.Hits {
+		if hit.ID != fmt.Sprint(i) {
+			t.Errorf("expected hit %d to keep its order, got '%s'", i, hit.ID)
+			break
+		}
+	}
+
+	// Pages are as large as the first one returned by the cluster.
+	if fake.requests != 7 {
+		t.Errorf("expected 7 pages to be fetched, got %d", fake.requests)
+	}
+	if fake.maxInFlight > 2 {
+		t.Errorf("expected at most 2 concurrent requests, got %d", fake.maxInFlight)
+	}
+}
+
+func TestFetchQueryResultsSinglePage(t *testing.T) {
+	fake := &fakeQueryCluster{total: 3, pageSize: 10}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	query := normalizeQuery(payload.NewStructuredQuery(payload.NewExpression()), 10)
+	response, err := fetchQueryResults(context.Background(), query, newTestClient(t, server.URL), 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(response.Hits) != 3 || fake.requests != 1 {
+		t.Errorf("expected 3 hits from a single request, got %d hits from %d requests", len(response.Hits), fake.requests)
+	}
+}
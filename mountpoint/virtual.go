@@ -4,6 +4,7 @@ import (
 	"context"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/menmos/menmos-mount/entry"
 	"github.com/rclone/rclone/fs"
@@ -44,7 +45,7 @@ func (m *virtualMount) ListEntries(ctx context.Context, pathSegment string, full
 	return nil, fs.ErrorDirNotFound
 }
 
-func (m *virtualMount) ResolveBlobDirectory(path string) (*entry.DirectoryBlobEntry, bool) {
+func (m *virtualMount) ResolveBlobDirectory(ctx context.Context, path string) (*entry.DirectoryBlobEntry, bool) {
 	splittedPath := strings.SplitN(path, "/", 2)
 	head := splittedPath[0]
 
@@ -61,13 +62,13 @@ func (m *virtualMount) ResolveBlobDirectory(path string) (*entry.DirectoryBlobEn
 	}
 
 	if mount, ok := m.mounts[head]; ok {
-		return mount.ResolveBlobDirectory(tail)
+		return mount.ResolveBlobDirectory(ctx, tail)
 	}
 
 	return nil, false
 }
 
-func (m *virtualMount) ResolveBlobFile(path string) (*entry.FileBlobEntry, bool) {
+func (m *virtualMount) ResolveBlobFile(ctx context.Context, path string) (*entry.FileBlobEntry, bool) {
 	fs.Infof(nil, "vmount resolving blob file: %s", path)
 	splittedPath := strings.SplitN(path, "/", 2)
 	head := splittedPath[0]
@@ -85,8 +86,112 @@ func (m *virtualMount) ResolveBlobFile(path string) (*entry.FileBlobEntry, bool)
 	}
 
 	if mount, ok := m.mounts[head]; ok {
-		return mount.ResolveBlobFile(tail)
+		return mount.ResolveBlobFile(ctx, tail)
 	}
 
 	return nil, false
 }
+
+func (m *virtualMount) Invalidate(path string) {
+	splittedPath := strings.SplitN(path, "/", 2)
+	head := splittedPath[0]
+
+	if head == "" || head == "." {
+		// Virtual mount directories are not cached.
+		return
+	}
+
+	var tail string
+	if len(splittedPath) == 2 {
+		tail = splittedPath[1]
+	} else {
+		tail = ""
+	}
+
+	if mount, ok := m.mounts[head]; ok {
+		mount.Invalidate(tail)
+	}
+}
+
+func (m *virtualMount) InvalidateTree(path string) {
+	splittedPath := strings.SplitN(path, "/", 2)
+	head := splittedPath[0]
+
+	if head == "" || head == "." {
+		for _, mount := range m.mounts {
+			mount.InvalidateTree("")
+		}
+		return
+	}
+
+	var tail string
+	if len(splittedPath) == 2 {
+		tail = splittedPath[1]
+	} else {
+		tail = ""
+	}
+
+	if mount, ok := m.mounts[head]; ok {
+		mount.InvalidateTree(tail)
+	}
+}
+
+func (m *virtualMount) PathCacheStats() PathCacheStats {
+	var stats PathCacheStats
+	for _, mount := range m.mounts {
+		stats = stats.Add(mount.PathCacheStats())
+	}
+	return stats
+}
+
+func (m *virtualMount) CachedPaths() map[string]string {
+	paths := make(map[string]string)
+	for mountName, mount := range m.mounts {
+		for subPath, blobID := range mount.CachedPaths() {
+			paths[path.Join(mountName, subPath)] = blobID
+		}
+	}
+	return paths
+}
+
+func (m *virtualMount) RestorePaths(paths map[string]string) {
+	subPaths := make(map[string]map[string]string)
+	for fullPath, blobID := range paths {
+		splittedPath := strings.SplitN(fullPath, "/", 2)
+		if len(splittedPath) != 2 {
+			// Virtual mount directories are not cached.
+			continue
+		}
+
+		head := splittedPath[0]
+		if _, ok := subPaths[head]; !ok {
+			subPaths[head] = make(map[string]string)
+		}
+		subPaths[head][splittedPath[1]] = blobID
+	}
+
+	for mountName, mountPaths := range subPaths {
+		if mount, ok := m.mounts[mountName]; ok {
+			mount.RestorePaths(mountPaths)
+		}
+	}
+}
+
+func (m *virtualMount) Poll(ctx context.Context, defaultInterval time.Duration, notify ChangeNotifyFunc) {
+	for mountName, mount := range m.mounts {
+		mountName := mountName
+		mount.Poll(ctx, defaultInterval, func(subPath string, entryType fs.EntryType) {
+			notify(path.Join(mountName, subPath), entryType)
+		})
+	}
+}
+
+func (m *virtualMount) PollInterval() time.Duration {
+	var interval time.Duration
+	for _, mount := range m.mounts {
+		if mountInterval := mount.PollInterval(); mountInterval > 0 && (interval == 0 || mountInterval < interval) {
+			interval = mountInterval
+		}
+	}
+	return interval
+}
@@ -0,0 +1,200 @@
+package mountpoint
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/menmos/menmos-go/payload"
+	"github.com/menmos/menmos-mount/cluster"
+	"github.com/menmos/menmos-mount/entry"
+	"github.com/rclone/rclone/fs"
+)
+
+// ChangeNotifyFunc is called with the path of every entry that changed, relative to the polled mount.
+type ChangeNotifyFunc func(path string, entryType fs.EntryType)
+
+// A watcher remembers the query & results behind the directories most recently listed in a mount,
+// so the directories can be polled for changes made by other clients.
+type watcher struct {
+	mutex sync.Mutex
+
+	interval       time.Duration
+	lastPoll       time.Time
+	directories    map[string]*list.Element // Of *watchedDirectory, keyed by path within the mount.
+	order          *list.List               // Most recently listed first.
+	maxDirectories int
+}
+
+type watchedDirectory struct {
+	path     string
+	query    *payload.Query
+	children map[string]watchedChild // Keyed by blob ID.
+}
+
+type watchedChild struct {
+	name        string
+	entryType   fs.EntryType
+	fingerprint string
+}
+
+// newWatcher returns a watcher polling at most `maxDirectories` directories, the least recently listed ones being
+// forgotten first.
+func newWatcher(interval time.Duration, maxDirectories int) *watcher {
+	if maxDirectories <= 0 {
+		maxDirectories = DefaultPathCacheSize
+	}
+
+	return &watcher{
+		interval:       interval,
+		directories:    make(map[string]*list.Element),
+		order:          list.New(),
+		maxDirectories: maxDirectories,
+	}
+}
+
+func watchedChildren(hits []payload.Hit) map[string]watchedChild {
+	children := make(map[string]watchedChild, len(hits))
+	for _, hit := range hits {
+		entryType := fs.EntryObject
+		if hit.Metadata.BlobType != "File" {
+			entryType = fs.EntryDirectory
+		}
+
+		// Every write through a mount bumps the change time, other writers at least change the size.
+		fingerprint := fmt.Sprintf("%d/%s/%s", hit.Metadata.Size, hit.Metadata.Metadata[entry.ModTimeMetaKey], hit.Metadata.Metadata[entry.ChangeTimeMetaKey])
+		children[hit.ID] = watchedChild{name: hit.Metadata.Name, entryType: entryType, fingerprint: fingerprint}
+	}
+	return children
+}
+
+// watch records the results of the query listing the directory at `dirPath`.
+func (w *watcher) watch(dirPath string, query *payload.Query, results *payload.QueryResponse) {
+	if w.interval < 0 {
+		return
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	directory := &watchedDirectory{path: dirPath, query: query, children: watchedChildren(results.Hits)}
+	if element, ok := w.directories[dirPath]; ok {
+		element.Value = directory
+		w.order.MoveToFront(element)
+		return
+	}
+
+	w.directories[dirPath] = w.order.PushFront(directory)
+	for w.order.Len() > w.maxDirectories {
+		oldest := w.order.Back()
+		w.order.Remove(oldest)
+		delete(w.directories, oldest.Value.(*watchedDirectory).path)
+	}
+}
+
+// forgetTree stops watching a directory and all directories below it.
+func (w *watcher) forgetTree(dirPath string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.forgetTreeLocked(dirPath)
+}
+
+func (w *watcher) forgetTreeLocked(dirPath string) {
+	if dirPath == "" {
+		w.directories = make(map[string]*list.Element)
+		w.order.Init()
+		return
+	}
+
+	prefix := dirPath + "/"
+	for watchedPath, element := range w.directories {
+		if watchedPath == dirPath || strings.HasPrefix(watchedPath, prefix) {
+			w.order.Remove(element)
+			delete(w.directories, watchedPath)
+		}
+	}
+}
+
+// due returns whether the watched directories should be polled, using `defaultInterval` if the mount has no
+// poll interval of its own.
+func (w *watcher) due(now time.Time, defaultInterval time.Duration) bool {
+	interval := w.interval
+	if interval == 0 {
+		interval = defaultInterval
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if interval <= 0 || now.Sub(w.lastPoll) < interval {
+		return false
+	}
+
+	w.lastPoll = now
+	return true
+}
+
+// poll re-runs the queries of all watched directories and notifies the entries that were added, removed, renamed
+// or modified since the last time the directories were listed.
+// `refreshed` is called with the fresh results of every polled directory.
+func (w *watcher) poll(ctx context.Context, client *cluster.Client, options Options, refreshed func(dirPath string, results *payload.QueryResponse), notify ChangeNotifyFunc) {
+	w.mutex.Lock()
+	directories := make(map[string]*watchedDirectory, len(w.directories))
+	for dirPath, element := range w.directories {
+		directories[dirPath] = element.Value.(*watchedDirectory)
+	}
+	w.mutex.Unlock()
+
+	for dirPath, directory := range directories {
+		if ctx.Err() != nil {
+			return
+		}
+
+		results, err := refreshQueryResults(ctx, directory.query, client, options)
+		if err != nil {
+			fs.Errorf(nil, "failed to poll '%s' for changes: %v", dirPath, err)
+			continue
+		}
+
+		refreshed(dirPath, results)
+		children := watchedChildren(results.Hits)
+
+		w.mutex.Lock()
+		element, ok := w.directories[dirPath]
+		if !ok || element.Value != directory {
+			// The directory was listed or forgotten while we were polling it, its results are more recent than ours.
+			w.mutex.Unlock()
+			continue
+		}
+		element.Value = &watchedDirectory{path: dirPath, query: directory.query, children: children}
+
+		var changed []watchedChild
+		for blobID, oldChild := range directory.children {
+			newChild, ok := children[blobID]
+			if !ok || newChild.name != oldChild.name {
+				// Removed or renamed: the old path is gone, and so is everything that was listed below it.
+				changed = append(changed, oldChild)
+				w.forgetTreeLocked(path.Join(dirPath, oldChild.name))
+			}
+
+			if ok && (newChild.name != oldChild.name || newChild.fingerprint != oldChild.fingerprint) {
+				changed = append(changed, newChild)
+			}
+		}
+		for blobID, newChild := range children {
+			if _, ok := directory.children[blobID]; !ok {
+				changed = append(changed, newChild)
+			}
+		}
+		w.mutex.Unlock()
+
+		for _, child := range changed {
+			notify(path.Join(dirPath, child.name), child.entryType)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package mountpoint
+
+import (
+	"testing"
+
+	"github.com/menmos/menmos-go/payload"
+)
+
+func TestWatcherForgetsLeastRecentlyListedDirectories(t *testing.T) {
+	w := newWatcher(0, 2)
+	results := &payload.QueryResponse{}
+
+	w.watch("a", payload.NewStructuredQuery(payload.NewExpression()), results)
+	w.watch("b", payload.NewStructuredQuery(payload.NewExpression()), results)
+	w.watch("a", payload.NewStructuredQuery(payload.NewExpression()), results)
+	w.watch("c", payload.NewStructuredQuery(payload.NewExpression()), results)
+
+	if _, ok := w.directories["b"]; ok {
+		t.Error("expected 'b' to be forgotten")
+	}
+	for _, dirPath := range []string{"a", "c"} {
+		if _, ok := w.directories[dirPath]; !ok {
+			t.Errorf("expected '%s' to be watched", dirPath)
+		}
+	}
+	if w.order.Len() != 2 {
+		t.Errorf("expected 2 watched directories, got %d", w.order.Len())
+	}
+}
+
+func TestWatcherForgetTree(t *testing.T) {
+	w := newWatcher(0, 0)
+	results := &payload.QueryResponse{}
+
+	for _, dirPath := range []string{"a", "a/b", "ab"} {
+		w.watch(dirPath, payload.NewStructuredQuery(payload.NewExpression()), results)
+	}
+
+	w.forgetTree("a")
+
+	if len(w.directories) != 1 || w.order.Len() != 1 {
+		t.Errorf("expected a single watched directory, got %d", len(w.directories))
+	}
+	if _, ok := w.directories["ab"]; !ok {
+		t.Error("expected 'ab' to be watched")
+	}
+}